@@ -0,0 +1,58 @@
+package bloom
+
+// testBatchSize is the number of keys whose probes are interleaved by
+// TestBatch, it bounds the digests held at once to a small stack buffer.
+const testBatchSize = 32
+
+// TestBatch tests if each of keys is in the set and stores the result for
+// keys[i] in out[i], out must be at least as long as keys.
+// All keys in a batch are hashed before any bits are probed and then the
+// i-th probe of every key is made before the (i+1)-th probe of any key.
+// Since the probes of different keys are independent loads the processor
+// can have many cache misses outstanding at once, rather than the k
+// dependent cache misses per key that Test takes, which makes lookups
+// against large mmap'd filters considerably faster.
+func (b *ConcurrentReadOnlyBloomFilter) TestBatch(keys [][]byte, out []bool) {
+	_ = out[:len(keys)]
+
+	var digests [testBatchSize]Digest
+	for len(keys) > 0 {
+		n := len(keys)
+		if n > testBatchSize {
+			n = testBatchSize
+		}
+		batch, results := keys[:n], out[:n]
+		for j, key := range batch {
+			digests[j] = Digest(b.hasher.Sum(key))
+			results[j] = true
+		}
+
+		for i := uint64(0); i < b.k; i++ {
+			remaining := false
+			for j := range batch {
+				if !results[j] {
+					continue
+				}
+				if testBit(b.data, bloomFilterLocation(digests[j], i, b.m)) {
+					remaining = true
+				} else {
+					results[j] = false
+				}
+			}
+			if !remaining {
+				break
+			}
+		}
+
+		keys, out = keys[n:], out[n:]
+	}
+}
+
+// testBit tests bit i of a bitset written as little endian 64 bit words,
+// bits past the last whole word are not set as in bitset.ReadOnlyBitSet.
+func testBit(data []byte, i uint) bool {
+	if i>>6 >= uint(len(data)>>3) {
+		return false
+	}
+	return data[i>>3]&(1<<(i&7)) != 0
+}
@@ -0,0 +1,108 @@
+package bloom
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTestBatch(t *testing.T) {
+	for _, hasher := range []Hasher{Murmur3Hasher, WyhashHasher} {
+		f := NewBloomFilterWithHasher(10000, 5, hasher)
+		keys := make([][]byte, 3*testBatchSize+7)
+		for i := range keys {
+			keys[i] = make([]byte, 8)
+			endianness.PutUint64(keys[i], rand.Uint64())
+			if i%3 == 0 {
+				f.Add(keys[i])
+			}
+		}
+
+		buf := bytes.NewBuffer(nil)
+		require.NoError(t, f.BitSet().Write(buf))
+		ro := NewConcurrentReadOnlyBloomFilterWithHasher(f.M(), f.K(), buf.Bytes(), hasher)
+
+		out := make([]bool, len(keys))
+		ro.TestBatch(keys, out)
+		for i, key := range keys {
+			require.Equal(t, ro.Test(key), out[i])
+			if i%3 == 0 {
+				require.True(t, out[i])
+			}
+		}
+
+		ro.TestBatch(nil, nil)
+		require.Panics(t, func() { ro.TestBatch(keys, out[:1]) })
+	}
+}
+
+func TestTestBatchShortData(t *testing.T) {
+	f := NewBloomFilter(1000, 4)
+	n1 := []byte("Bess")
+	f.Add(n1)
+
+	buf := bytes.NewBuffer(nil)
+	require.NoError(t, f.BitSet().Write(buf))
+
+	// Bits past the end of data are not set, as with Test.
+	ro := NewConcurrentReadOnlyBloomFilter(f.M(), f.K(), buf.Bytes()[:16])
+	out := make([]bool, 1)
+	ro.TestBatch([][]byte{n1}, out)
+	require.Equal(t, ro.Test(n1), out[0])
+}
+
+func newBenchConcurrentReadOnlyBloomFilter(
+	m, k uint,
+	n int,
+) *ConcurrentReadOnlyBloomFilter {
+	var buff [8]byte
+	slice := buff[:]
+
+	data := make([]byte, bitSetBytesLen(uint64(m)))
+	for i := 0; i < n; i++ {
+		endianness.PutUint64(slice, uint64(rand.Uint32()))
+		h := Hash(slice)
+		for j := uint64(0); j < uint64(k); j++ {
+			loc := bloomFilterLocation(h, j, uint64(m))
+			data[loc>>3] |= 1 << (loc & 7)
+		}
+	}
+	return NewConcurrentReadOnlyBloomFilter(m, k, data)
+}
+
+func newBenchKeys(n int) [][]byte {
+	keys := make([][]byte, n)
+	for i := range keys {
+		keys[i] = make([]byte, 8)
+		endianness.PutUint64(keys[i], uint64(rand.Uint32()))
+	}
+	return keys
+}
+
+func BenchmarkConcurrentContains100kX10BX20(b *testing.B) {
+	b.StopTimer()
+	bf := newBenchConcurrentReadOnlyBloomFilter(10*1000*1000*1000, 20, 100*1000)
+	keys := newBenchKeys(1 << 20)
+	b.StartTimer()
+	for i := 0; i < b.N; i++ {
+		bf.Test(keys[i%len(keys)])
+	}
+}
+
+func BenchmarkTestBatch100kX10BX20(b *testing.B) {
+	b.StopTimer()
+	bf := newBenchConcurrentReadOnlyBloomFilter(10*1000*1000*1000, 20, 100*1000)
+	keys := newBenchKeys(1 << 20)
+	out := make([]bool, len(keys))
+	b.StartTimer()
+	// Each iteration tests a single key, as with the other benchmarks.
+	for i := 0; i < b.N; i += len(keys) {
+		n := len(keys)
+		if b.N-i < n {
+			n = b.N - i
+		}
+		bf.TestBatch(keys[:n], out)
+	}
+}
@@ -0,0 +1,302 @@
+package bloom
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"math/bits"
+)
+
+const (
+	// blockedHeaderMagic identifies a serialized blocked bloom filter, it
+	// is "M3BB" when read as little endian bytes.
+	blockedHeaderMagic uint32 = 0x4242334d
+	// blockBits is the number of bits in a block, one 64 byte cache line.
+	blockBits = 512
+	// blockWords is the number of 64 bit words in a block.
+	blockWords = blockBits / 64
+	// maxBlockedM is the largest m of a blocked bloom filter, rounding a
+	// larger m up to a whole number of blocks would overflow.
+	maxBlockedM = math.MaxUint64 - blockBits
+)
+
+// blockedLayout is the layout of the blocked bloom filters.
+var blockedLayout = layout{
+	magic:      blockedHeaderMagic,
+	payloadLen: blockedBytesLen,
+	maxM:       maxBlockedM,
+}
+
+// blockedBytesLen returns the number of bytes of a blocked bloom filter of
+// m bits, which is rounded up to a whole number of blocks.
+func blockedBytesLen(m uint64) uint64 {
+	return (m + blockBits - 1) / blockBits * blockBits / 8
+}
+
+// blockedLocation returns the block of a digest and the location of its
+// i-th bit within the block. Each bit is taken from a separate splitmix64
+// step rather than by double hashing, since with only 512 locations the
+// bits of double hashing are correlated enough to noticeably raise the
+// false positive rate.
+func blockedLocation(h Digest, i, blocks uint64) (uint64, uint64) {
+	block, _ := bits.Mul64(h[0], blocks)
+	return block, splitmix64(h[2]+i*0x9e3779b97f4a7c15) >> (64 - 9)
+}
+
+// BlockedBloomFilter is a bloom filter set membership that sets all k bits
+// of a value within a single 512 bit block, so that adding or testing a
+// value touches one 64 byte cache line rather than k of them. This makes
+// lookups against filters much larger than the processor caches, and in
+// particular mmap'd filters, considerably faster. The cost is a higher
+// false positive rate than BloomFilter for the same m and k, since blocks
+// are unevenly loaded. With OptimalK the false positive rate is about 1.1
+// times that of BloomFilter at 8 bits per value, 1.3 times at 12, 2 times
+// at 16 and 8 times at 24, see Putze et al. "Cache-, Hash- and
+// Space-Efficient Bloom Filters". m is rounded up to a multiple of 512.
+// It cannot be concurrently read or written to, a sync.Mutex must be used
+// to guard read/write access if desired.
+type BlockedBloomFilter struct {
+	m      uint64
+	k      uint64
+	words  []uint64
+	hasher Hasher
+}
+
+// NewBlockedBloomFilter creates a new blocked bloom filter that can
+// represent m elements with k hashes. It is not concurrent read or write
+// safe.
+func NewBlockedBloomFilter(m uint, k uint) *BlockedBloomFilter {
+	return NewBlockedBloomFilterWithHasher(m, k, Murmur3Hasher)
+}
+
+// NewBlockedBloomFilterWithHasher creates a new blocked bloom filter that
+// can represent m elements with k hashes using a hasher, or Murmur3Hasher
+// if nil. It is not concurrent read or write safe.
+func NewBlockedBloomFilterWithHasher(
+	m uint,
+	k uint,
+	hasher Hasher,
+) *BlockedBloomFilter {
+	hasher = hasherOrDefault(hasher)
+	if m < 1 {
+		m = 1
+	}
+	if k < 1 {
+		k = 1
+	}
+	size := blockedBytesLen(uint64(m))
+	return &BlockedBloomFilter{
+		m:      size * 8,
+		k:      uint64(k),
+		words:  make([]uint64, size/8),
+		hasher: hasher,
+	}
+}
+
+// Add value to the set.
+func (b *BlockedBloomFilter) Add(value []byte) {
+	b.AddDigest(Digest(b.hasher.Sum(value)))
+}
+
+// AddDigest adds the value with a digest to the set, the digest must
+// have been computed with the hasher of the filter.
+func (b *BlockedBloomFilter) AddDigest(h Digest) {
+	blocks := b.m / blockBits
+	for i := uint64(0); i < b.k; i++ {
+		block, loc := blockedLocation(h, i, blocks)
+		b.words[block*blockWords+loc>>6] |= 1 << (loc & 63)
+	}
+}
+
+// Test if value is in the set.
+func (b *BlockedBloomFilter) Test(value []byte) bool {
+	return b.TestDigest(Digest(b.hasher.Sum(value)))
+}
+
+// TestDigest tests if the value with a digest is in the set, the
+// digest must have been computed with the hasher of the filter.
+func (b *BlockedBloomFilter) TestDigest(h Digest) bool {
+	blocks := b.m / blockBits
+	for i := uint64(0); i < b.k; i++ {
+		block, loc := blockedLocation(h, i, blocks)
+		if b.words[block*blockWords+loc>>6]&(1<<(loc&63)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// M returns the m elements represented.
+func (b *BlockedBloomFilter) M() uint {
+	return uint(b.m)
+}
+
+// K returns the k hashes used.
+func (b *BlockedBloomFilter) K() uint {
+	return uint(b.k)
+}
+
+// Hasher returns the hasher used.
+func (b *BlockedBloomFilter) Hasher() Hasher {
+	return b.hasher
+}
+
+// WriteTo writes the blocked bloom filter to a stream with a header
+// describing m, k and the hash scheme.
+func (b *BlockedBloomFilter) WriteTo(w io.Writer) (int64, error) {
+	return blockedLayout.write(w, b.m, b.k, b.hasher, b.bytes())
+}
+
+// ReadFrom reads a blocked bloom filter previously written with WriteTo
+// from a stream, replacing the contents of the blocked bloom filter. If the
+// filter has a hasher the stream must have been written with the same
+// hasher.
+func (b *BlockedBloomFilter) ReadFrom(r io.Reader) (int64, error) {
+	h, payload, hasher, read, err := blockedLayout.read(r, b.hasher)
+	if err != nil {
+		return read, err
+	}
+	if err := validateBlocks(h, payload); err != nil {
+		return read, err
+	}
+	words := make([]uint64, len(payload)/8)
+	for i := range words {
+		words[i] = binary.LittleEndian.Uint64(payload[8*i:])
+	}
+	b.m = h.M
+	b.k = h.K
+	b.words = words
+	b.hasher = hasher
+	return read, nil
+}
+
+func (b *BlockedBloomFilter) bytes() []byte {
+	data := make([]byte, 8*len(b.words))
+	for i, w := range b.words {
+		binary.LittleEndian.PutUint64(data[8*i:], w)
+	}
+	return data
+}
+
+// ReadOnlyBlockedBloomFilter is a read only blocked bloom filter set
+// membership backed by a byte slice, this means it can be used with a
+// mmap'd bytes ref. It is not concurrent read or write safe.
+type ReadOnlyBlockedBloomFilter struct {
+	readOnlyBlocked
+}
+
+// NewReadOnlyBlockedBloomFilterFromBytes returns a new read only blocked
+// bloom filter from data previously written with BlockedBloomFilter.WriteTo,
+// the bits are not copied so data can be a mmap'd bytes ref.
+// It is not concurrent read or write safe.
+func NewReadOnlyBlockedBloomFilterFromBytes(
+	data []byte,
+	opts ParseOptions,
+) (*ReadOnlyBlockedBloomFilter, error) {
+	b, err := newReadOnlyBlocked(data, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &ReadOnlyBlockedBloomFilter{readOnlyBlocked: b}, nil
+}
+
+// ConcurrentReadOnlyBlockedBloomFilter is a concurrent read only blocked
+// bloom filter set membership backed by a byte slice, this means it can be
+// used with a mmap'd bytes ref. It can be concurrently read from by any
+// number of readers.
+type ConcurrentReadOnlyBlockedBloomFilter struct {
+	readOnlyBlocked
+}
+
+// NewConcurrentReadOnlyBlockedBloomFilterFromBytes returns a new concurrent
+// read only blocked bloom filter from data previously written with
+// BlockedBloomFilter.WriteTo, the bits are not copied so data can be a
+// mmap'd bytes ref. It can be concurrently read from by any number of
+// readers.
+func NewConcurrentReadOnlyBlockedBloomFilterFromBytes(
+	data []byte,
+	opts ParseOptions,
+) (*ConcurrentReadOnlyBlockedBloomFilter, error) {
+	b, err := newReadOnlyBlocked(data, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &ConcurrentReadOnlyBlockedBloomFilter{readOnlyBlocked: b}, nil
+}
+
+// readOnlyBlocked implements the read only blocked bloom filters, which
+// only differ in their documented concurrency guarantees.
+type readOnlyBlocked struct {
+	m        uint64
+	k        uint64
+	data     []byte
+	hasher   Hasher
+	checksum *storedChecksum
+}
+
+func newReadOnlyBlocked(data []byte, opts ParseOptions) (readOnlyBlocked, error) {
+	h, payload, hasher, sum, err := blockedLayout.parsePayload(data, opts)
+	if err != nil {
+		return readOnlyBlocked{}, err
+	}
+	if err := validateBlocks(h, payload); err != nil {
+		return readOnlyBlocked{}, err
+	}
+	return readOnlyBlocked{
+		m:        h.M,
+		k:        h.K,
+		data:     payload,
+		hasher:   hasher,
+		checksum: sum,
+	}, nil
+}
+
+// Test if value is in the set.
+func (b *readOnlyBlocked) Test(value []byte) bool {
+	return b.TestDigest(Digest(b.hasher.Sum(value)))
+}
+
+// TestDigest tests if the value with a digest is in the set, the
+// digest must have been computed with the hasher of the filter.
+func (b *readOnlyBlocked) TestDigest(h Digest) bool {
+	blocks := b.m / blockBits
+	for i := uint64(0); i < b.k; i++ {
+		block, loc := blockedLocation(h, i, blocks)
+		if b.data[block*blockBits/8+loc>>3]&(1<<(loc&7)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// M returns the m elements represented.
+func (b *readOnlyBlocked) M() uint {
+	return uint(b.m)
+}
+
+// K returns the k hashes used.
+func (b *readOnlyBlocked) K() uint {
+	return uint(b.k)
+}
+
+// Hasher returns the hasher used.
+func (b *readOnlyBlocked) Hasher() Hasher {
+	return b.hasher
+}
+
+// Verify verifies the bits of the filter against the checksum stored when
+// it was serialized.
+func (b *readOnlyBlocked) Verify() error {
+	return b.checksum.verify()
+}
+
+// validateBlocks validates that every block a digest can select is within
+// the payload.
+func validateBlocks(h Header, payload []byte) error {
+	if blocks := h.M / blockBits; blocks > uint64(len(payload))/(blockWords*8) {
+		return fmt.Errorf("%w: %d blocks, payload=%d",
+			ErrPayloadLength, blocks, len(payload))
+	}
+	return nil
+}
@@ -0,0 +1,185 @@
+package bloom
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBlockedBloomFilter(t *testing.T) {
+	f := NewBlockedBloomFilter(1000, 4)
+	n1 := []byte("Bess")
+	n2 := []byte("Jane")
+	n3 := []byte("Emma")
+	f.Add(n1)
+	f.Add(n3)
+
+	require.Equal(t, uint(1024), f.M())
+	require.Equal(t, uint(4), f.K())
+	require.True(t, f.Test(n1))
+	require.False(t, f.Test(n2))
+	require.True(t, f.Test(n3))
+}
+
+func TestBlockedBloomFilterNoFalseNegatives(t *testing.T) {
+	m, k := EstimateFalsePositiveRate(10000, 0.01)
+	f := NewBlockedBloomFilter(m, k)
+	var buff [8]byte
+	for i := 0; i < 10000; i++ {
+		endianness.PutUint64(buff[:], uint64(i))
+		f.Add(buff[:])
+	}
+	for i := 0; i < 10000; i++ {
+		endianness.PutUint64(buff[:], uint64(i))
+		require.True(t, f.Test(buff[:]))
+	}
+}
+
+func TestBlockedBloomFilterFalsePositiveRate(t *testing.T) {
+	// At 16 bits per value an ideal blocked bloom filter has about twice
+	// the false positive rate of a classic one, correlated bit locations
+	// within a block raise it further.
+	const n = 10000
+	k, err := OptimalK(16*n, n)
+	require.NoError(t, err)
+	classic := NewBloomFilter(16*n, k)
+	blocked := NewBlockedBloomFilter(16*n, k)
+	var buff [8]byte
+	for i := 0; i < n; i++ {
+		endianness.PutUint64(buff[:], uint64(i))
+		classic.Add(buff[:])
+		blocked.Add(buff[:])
+	}
+
+	var classicFP, blockedFP int
+	for i := n; i < 101*n; i++ {
+		endianness.PutUint64(buff[:], uint64(i))
+		if classic.Test(buff[:]) {
+			classicFP++
+		}
+		if blocked.Test(buff[:]) {
+			blockedFP++
+		}
+	}
+
+	// Blocking trades a higher false positive rate for locality.
+	require.True(t, blockedFP >= classicFP,
+		"blocked: %d, classic: %d", blockedFP, classicFP)
+	require.True(t, float64(blockedFP) < 2.5*float64(classicFP),
+		"blocked: %d, classic: %d", blockedFP, classicFP)
+}
+
+func TestBlockedBloomFilterWriteToReadFrom(t *testing.T) {
+	f := NewBlockedBloomFilterWithHasher(1000, 4, XXHash64Hasher)
+	n1 := []byte("Bess")
+	n2 := []byte("Jane")
+	f.Add(n1)
+
+	buf := bytes.NewBuffer(nil)
+	written, err := f.WriteTo(buf)
+	require.NoError(t, err)
+	require.Equal(t, int64(buf.Len()), written)
+	require.Equal(t, headerLen+1024/8, buf.Len())
+
+	var r BlockedBloomFilter
+	read, err := r.ReadFrom(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	require.Equal(t, written, read)
+	require.Equal(t, f.M(), r.M())
+	require.Equal(t, f.K(), r.K())
+	require.Equal(t, HashSchemeXXHash64, r.Hasher().Scheme())
+	require.True(t, r.Test(n1))
+	require.False(t, r.Test(n2))
+
+	opts := ParseOptions{VerifyChecksum: true}
+	ro, err := NewReadOnlyBlockedBloomFilterFromBytes(buf.Bytes(), opts)
+	require.NoError(t, err)
+	require.Equal(t, f.M(), ro.M())
+	require.Equal(t, f.K(), ro.K())
+	require.True(t, ro.Test(n1))
+	require.False(t, ro.Test(n2))
+	require.NoError(t, ro.Verify())
+
+	cro, err := NewConcurrentReadOnlyBlockedBloomFilterFromBytes(buf.Bytes(), opts)
+	require.NoError(t, err)
+	require.True(t, cro.Test(n1))
+	require.False(t, cro.Test(n2))
+	require.NoError(t, cro.Verify())
+}
+
+func TestBlockedBloomFilterFormatMismatch(t *testing.T) {
+	blocked := bytes.NewBuffer(nil)
+	_, err := NewBlockedBloomFilter(1024, 4).WriteTo(blocked)
+	require.NoError(t, err)
+
+	classic := bytes.NewBuffer(nil)
+	_, err = NewBloomFilter(1024, 4).WriteTo(classic)
+	require.NoError(t, err)
+
+	_, err = NewReadOnlyBloomFilterFromBytes(blocked.Bytes(), ParseOptions{})
+	require.True(t, errors.Is(err, ErrInvalidMagic), "unexpected error: %v", err)
+
+	_, err = NewReadOnlyBlockedBloomFilterFromBytes(classic.Bytes(), ParseOptions{})
+	require.True(t, errors.Is(err, ErrInvalidMagic), "unexpected error: %v", err)
+}
+
+func TestBlockedBloomFilterOverflowingM(t *testing.T) {
+	// A header with an m whose rounded up payload length wraps around to
+	// zero, followed by no payload.
+	var data [headerLen]byte
+	Header{
+		Version:    formatVersion,
+		HashScheme: Murmur3Hasher.Scheme(),
+		M:          math.MaxUint64,
+		K:          4,
+		PayloadLen: 0,
+	}.encode(data[:], blockedHeaderMagic)
+
+	_, err := NewReadOnlyBlockedBloomFilterFromBytes(data[:], ParseOptions{})
+	require.True(t, errors.Is(err, ErrPayloadLength), "unexpected error: %v", err)
+	_, err = NewConcurrentReadOnlyBlockedBloomFilterFromBytes(data[:], ParseOptions{})
+	require.True(t, errors.Is(err, ErrPayloadLength), "unexpected error: %v", err)
+	var r BlockedBloomFilter
+	_, err = r.ReadFrom(bytes.NewReader(data[:]))
+	require.True(t, errors.Is(err, ErrPayloadLength), "unexpected error: %v", err)
+
+	// A payload too short for the blocks of m.
+	require.True(t, errors.Is(validateBlocks(Header{M: 2 * blockBits}, make([]byte, 64)),
+		ErrPayloadLength))
+	require.NoError(t, validateBlocks(Header{M: 2 * blockBits}, make([]byte, 128)))
+}
+
+func BenchmarkBlockedAddX10kX5(b *testing.B) {
+	var buff [8]byte
+	slice := buff[:]
+
+	b.StopTimer()
+	bf := NewBlockedBloomFilter(10000, 5)
+	b.StartTimer()
+
+	for i := 0; i < b.N; i++ {
+		endianness.PutUint64(slice, uint64(rand.Uint32()))
+		bf.Add(slice)
+	}
+}
+
+func BenchmarkBlockedContains100kX10BX20(b *testing.B) {
+	var buff [8]byte
+	slice := buff[:]
+
+	b.StopTimer()
+	bf := NewBlockedBloomFilter(10*1000*1000*1000, 20)
+	for i := 0; i < 100*1000; i++ {
+		endianness.PutUint64(slice, uint64(rand.Uint32()))
+		bf.Add(slice)
+	}
+	b.StartTimer()
+	for i := 0; i < b.N; i++ {
+		endianness.PutUint64(slice, uint64(rand.Uint32()))
+		bf.Test(slice)
+	}
+}
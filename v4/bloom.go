@@ -33,12 +33,31 @@ Similarly, to test if "Love" is in bloom:
 package bloom
 
 import (
+	"errors"
+	"fmt"
 	"math"
 
 	"github.com/m3db/bitset"
 )
 
-func bloomFilterLocation(h [4]uint64, i, m uint64) uint {
+// MaxK is the largest number of hashes accepted when validating
+// the parameters of a bloom filter.
+const MaxK = 128
+
+var (
+	// ErrZeroM is returned when validating a bloom filter with m of zero.
+	ErrZeroM = errors.New("bloom: m must be greater than zero")
+	// ErrZeroK is returned when validating a bloom filter with k of zero.
+	ErrZeroK = errors.New("bloom: k must be greater than zero")
+	// ErrKTooLarge is returned when validating a bloom filter with k
+	// greater than MaxK.
+	ErrKTooLarge = errors.New("bloom: k is too large")
+	// ErrDataLength is returned when validating a bloom filter backed by
+	// a byte slice whose length does not match m.
+	ErrDataLength = errors.New("bloom: data length does not match m")
+)
+
+func bloomFilterLocation(h Digest, i, m uint64) uint {
 	v := h[i%2] + i*h[2+(((i+(i%2))%4)/2)]
 	return uint(v % m)
 }
@@ -49,14 +68,23 @@ func bloomFilterLocation(h [4]uint64, i, m uint64) uint {
 // desired. This is due to a cached hash digest being used to avoid
 // allocation each read/write.
 type BloomFilter struct {
-	m   uint64
-	k   uint64
-	set *bitset.BitSet
+	m      uint64
+	k      uint64
+	set    *bitset.BitSet
+	hasher Hasher
 }
 
 // NewBloomFilter creates a new bloom filter that can represent
 // m elements with k hashes. It is not concurrent read or write safe.
 func NewBloomFilter(m uint, k uint) *BloomFilter {
+	return NewBloomFilterWithHasher(m, k, Murmur3Hasher)
+}
+
+// NewBloomFilterWithHasher creates a new bloom filter that can represent
+// m elements with k hashes using a hasher, or Murmur3Hasher if nil. It is
+// not concurrent read or write safe.
+func NewBloomFilterWithHasher(m uint, k uint, hasher Hasher) *BloomFilter {
+	hasher = hasherOrDefault(hasher)
 	if m < 1 {
 		m = 1
 	}
@@ -64,14 +92,18 @@ func NewBloomFilter(m uint, k uint) *BloomFilter {
 		k = 1
 	}
 	return &BloomFilter{
-		m:   uint64(m),
-		k:   uint64(k),
-		set: bitset.NewBitSet(m),
+		m:      uint64(m),
+		k:      uint64(k),
+		set:    bitset.NewBitSet(m),
+		hasher: hasher,
 	}
 }
 
 // EstimateFalsePositiveRate estimates m and k, based on:
 // https://stackoverflow.com/a/22467497
+//
+// Deprecated: Use PlanForFalsePositiveRate, which also validates n and p
+// and reports the expected false positive rate.
 func EstimateFalsePositiveRate(n uint, p float64) (m uint, k uint) {
 	floatM := (float64(-1) * float64(n) * math.Log(p)) / (math.Pow(math.Log(2), 2))
 	floatK := (floatM / float64(n)) * math.Log(2)
@@ -81,7 +113,12 @@ func EstimateFalsePositiveRate(n uint, p float64) (m uint, k uint) {
 
 // Add value to the set.
 func (b *BloomFilter) Add(value []byte) {
-	h := sum128WithEntropy(value)
+	b.AddDigest(Digest(b.hasher.Sum(value)))
+}
+
+// AddDigest adds the value with a digest to the set, the digest must
+// have been computed with the hasher of the filter.
+func (b *BloomFilter) AddDigest(h Digest) {
 	for i := uint64(0); i < b.k; i++ {
 		b.set.Set(bloomFilterLocation(h, i, b.m))
 	}
@@ -89,7 +126,12 @@ func (b *BloomFilter) Add(value []byte) {
 
 // Test if value is in the set.
 func (b *BloomFilter) Test(value []byte) bool {
-	h := sum128WithEntropy(value)
+	return b.TestDigest(Digest(b.hasher.Sum(value)))
+}
+
+// TestDigest tests if the value with a digest is in the set, the
+// digest must have been computed with the hasher of the filter.
+func (b *BloomFilter) TestDigest(h Digest) bool {
 	for i := uint64(0); i < b.k; i++ {
 		if !b.set.Test(bloomFilterLocation(h, i, b.m)) {
 			return false
@@ -113,31 +155,83 @@ func (b *BloomFilter) BitSet() *bitset.BitSet {
 	return b.set
 }
 
+// Hasher returns the hasher used.
+func (b *BloomFilter) Hasher() Hasher {
+	return b.hasher
+}
+
 // ReadOnlyBloomFilter is a read only bloom filter set membership.
 // It cannot be concurrently read or written to. Multiple concurrent readers
 // is also unsafe so a sync.Mutex must be used to guard read/write access if
 // desired. This is due to a cached hash digest being used to avoid
 // allocation each read/write.
 type ReadOnlyBloomFilter struct {
-	m   uint64
-	k   uint64
-	set *bitset.ReadOnlyBitSet
+	m        uint64
+	k        uint64
+	data     []byte
+	set      *bitset.ReadOnlyBitSet
+	hasher   Hasher
+	checksum *storedChecksum
 }
 
 // NewReadOnlyBloomFilter returns a new read only bloom filter backed
 // by a byte slice, this means it can be used with a mmap'd bytes ref.
 // It is not concurrent read or write safe.
 func NewReadOnlyBloomFilter(m, k uint, data []byte) *ReadOnlyBloomFilter {
+	return NewReadOnlyBloomFilterWithHasher(m, k, data, Murmur3Hasher)
+}
+
+// NewReadOnlyBloomFilterWithHasher returns a new read only bloom filter
+// backed by a byte slice that was built using a hasher, or Murmur3Hasher
+// if nil. It is not concurrent read or write safe.
+func NewReadOnlyBloomFilterWithHasher(
+	m, k uint,
+	data []byte,
+	hasher Hasher,
+) *ReadOnlyBloomFilter {
+	hasher = hasherOrDefault(hasher)
 	return &ReadOnlyBloomFilter{
-		m:   uint64(m),
-		k:   uint64(k),
-		set: bitset.NewReadOnlyBitSet(data),
+		m:      uint64(m),
+		k:      uint64(k),
+		data:   data,
+		set:    bitset.NewReadOnlyBitSet(data),
+		hasher: hasher,
+	}
+}
+
+// NewValidatedReadOnlyBloomFilter returns a new read only bloom filter
+// backed by a byte slice like NewReadOnlyBloomFilter, but returns an error
+// rather than deferring a panic to Test if m, k or the length of data
+// are invalid.
+func NewValidatedReadOnlyBloomFilter(
+	m, k uint,
+	data []byte,
+) (*ReadOnlyBloomFilter, error) {
+	return NewValidatedReadOnlyBloomFilterWithHasher(m, k, data, Murmur3Hasher)
+}
+
+// NewValidatedReadOnlyBloomFilterWithHasher returns a new read only bloom
+// filter backed by a byte slice that was built using a hasher, or
+// Murmur3Hasher if nil, like NewValidatedReadOnlyBloomFilter.
+func NewValidatedReadOnlyBloomFilterWithHasher(
+	m, k uint,
+	data []byte,
+	hasher Hasher,
+) (*ReadOnlyBloomFilter, error) {
+	if err := validate(uint64(m), uint64(k), uint64(len(data))); err != nil {
+		return nil, err
 	}
+	return NewReadOnlyBloomFilterWithHasher(m, k, data, hasher), nil
 }
 
 // Test if value is in the set.
 func (b *ReadOnlyBloomFilter) Test(value []byte) bool {
-	h := sum128WithEntropy(value)
+	return b.TestDigest(Digest(b.hasher.Sum(value)))
+}
+
+// TestDigest tests if the value with a digest is in the set, the
+// digest must have been computed with the hasher of the filter.
+func (b *ReadOnlyBloomFilter) TestDigest(h Digest) bool {
 	for i := uint64(0); i < b.k; i++ {
 		if !b.set.Test(bloomFilterLocation(h, i, b.m)) {
 			return false
@@ -161,12 +255,20 @@ func (b *ReadOnlyBloomFilter) BitSet() *bitset.ReadOnlyBitSet {
 	return b.set
 }
 
+// Hasher returns the hasher used.
+func (b *ReadOnlyBloomFilter) Hasher() Hasher {
+	return b.hasher
+}
+
 // ConcurrentReadOnlyBloomFilter is a concurrent read only bloom filter set
 // membership. It can be concurrently read from by any number of readers.
 type ConcurrentReadOnlyBloomFilter struct {
-	m   uint64
-	k   uint64
-	set *bitset.ReadOnlyBitSet
+	m        uint64
+	k        uint64
+	data     []byte
+	set      *bitset.ReadOnlyBitSet
+	hasher   Hasher
+	checksum *storedChecksum
 }
 
 // NewConcurrentReadOnlyBloomFilter returns a new concurrent read only bloom
@@ -176,16 +278,62 @@ func NewConcurrentReadOnlyBloomFilter(
 	m, k uint,
 	data []byte,
 ) *ConcurrentReadOnlyBloomFilter {
+	return NewConcurrentReadOnlyBloomFilterWithHasher(m, k, data, Murmur3Hasher)
+}
+
+// NewConcurrentReadOnlyBloomFilterWithHasher returns a new concurrent read
+// only bloom filter backed by a byte slice that was built using a hasher,
+// or Murmur3Hasher if nil. It can be concurrently read from by any number
+// of readers.
+func NewConcurrentReadOnlyBloomFilterWithHasher(
+	m, k uint,
+	data []byte,
+	hasher Hasher,
+) *ConcurrentReadOnlyBloomFilter {
+	hasher = hasherOrDefault(hasher)
 	return &ConcurrentReadOnlyBloomFilter{
-		m:   uint64(m),
-		k:   uint64(k),
-		set: bitset.NewReadOnlyBitSet(data),
+		m:      uint64(m),
+		k:      uint64(k),
+		data:   data,
+		set:    bitset.NewReadOnlyBitSet(data),
+		hasher: hasher,
+	}
+}
+
+// NewValidatedConcurrentReadOnlyBloomFilter returns a new concurrent read
+// only bloom filter backed by a byte slice like
+// NewConcurrentReadOnlyBloomFilter, but returns an error rather than
+// deferring a panic to Test if m, k or the length of data are invalid.
+func NewValidatedConcurrentReadOnlyBloomFilter(
+	m, k uint,
+	data []byte,
+) (*ConcurrentReadOnlyBloomFilter, error) {
+	return NewValidatedConcurrentReadOnlyBloomFilterWithHasher(m, k, data, Murmur3Hasher)
+}
+
+// NewValidatedConcurrentReadOnlyBloomFilterWithHasher returns a new
+// concurrent read only bloom filter backed by a byte slice that was built
+// using a hasher, or Murmur3Hasher if nil, like
+// NewValidatedConcurrentReadOnlyBloomFilter.
+func NewValidatedConcurrentReadOnlyBloomFilterWithHasher(
+	m, k uint,
+	data []byte,
+	hasher Hasher,
+) (*ConcurrentReadOnlyBloomFilter, error) {
+	if err := validate(uint64(m), uint64(k), uint64(len(data))); err != nil {
+		return nil, err
 	}
+	return NewConcurrentReadOnlyBloomFilterWithHasher(m, k, data, hasher), nil
 }
 
 // Test if value is in the set.
 func (b *ConcurrentReadOnlyBloomFilter) Test(value []byte) bool {
-	h := sum128WithEntropy(value)
+	return b.TestDigest(Digest(b.hasher.Sum(value)))
+}
+
+// TestDigest tests if the value with a digest is in the set, the
+// digest must have been computed with the hasher of the filter.
+func (b *ConcurrentReadOnlyBloomFilter) TestDigest(h Digest) bool {
 	for i := uint64(0); i < b.k; i++ {
 		if !b.set.Test(bloomFilterLocation(h, i, b.m)) {
 			return false
@@ -208,3 +356,32 @@ func (b *ConcurrentReadOnlyBloomFilter) K() uint {
 func (b *ConcurrentReadOnlyBloomFilter) BitSet() *bitset.ReadOnlyBitSet {
 	return b.set
 }
+
+// Hasher returns the hasher used.
+func (b *ConcurrentReadOnlyBloomFilter) Hasher() Hasher {
+	return b.hasher
+}
+
+func validateParams(m, k uint64) error {
+	if m == 0 {
+		return ErrZeroM
+	}
+	if k == 0 {
+		return ErrZeroK
+	}
+	if k > MaxK {
+		return fmt.Errorf("%w: %d > %d", ErrKTooLarge, k, MaxK)
+	}
+	return nil
+}
+
+func validate(m, k, dataLen uint64) error {
+	if err := validateParams(m, k); err != nil {
+		return err
+	}
+	if expected := bitSetBytesLen(m); dataLen != expected {
+		return fmt.Errorf("%w: m=%d requires %d bytes, got %d",
+			ErrDataLength, m, expected, dataLen)
+	}
+	return nil
+}
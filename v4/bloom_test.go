@@ -3,6 +3,7 @@ package bloom
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"math"
 	"math/rand"
@@ -125,6 +126,102 @@ func TestReadOnly(t *testing.T) {
 	}
 }
 
+func TestValidatedReadOnly(t *testing.T) {
+	f := NewBloomFilter(1000, 4)
+	n1 := []byte("Bess")
+	f.Add(n1)
+
+	buf := bytes.NewBuffer(nil)
+	require.NoError(t, f.BitSet().Write(buf))
+	data := buf.Bytes()
+
+	ro, err := NewValidatedReadOnlyBloomFilter(f.M(), f.K(), data)
+	require.NoError(t, err)
+	require.True(t, ro.Test(n1))
+
+	cro, err := NewValidatedConcurrentReadOnlyBloomFilter(f.M(), f.K(), data)
+	require.NoError(t, err)
+	require.True(t, cro.Test(n1))
+
+	for _, hasher := range []Hasher{XXHash64Hasher, WyhashHasher} {
+		f := NewBloomFilterWithHasher(1000, 4, hasher)
+		f.Add(n1)
+		buf := bytes.NewBuffer(nil)
+		require.NoError(t, f.BitSet().Write(buf))
+
+		ro, err := NewValidatedReadOnlyBloomFilterWithHasher(f.M(), f.K(), buf.Bytes(), hasher)
+		require.NoError(t, err)
+		require.Equal(t, hasher, ro.Hasher())
+		require.True(t, ro.Test(n1))
+
+		cro, err := NewValidatedConcurrentReadOnlyBloomFilterWithHasher(f.M(), f.K(),
+			buf.Bytes(), hasher)
+		require.NoError(t, err)
+		require.Equal(t, hasher, cro.Hasher())
+		require.True(t, cro.Test(n1))
+
+		_, err = NewValidatedReadOnlyBloomFilterWithHasher(f.M(), 0, buf.Bytes(), hasher)
+		require.Equal(t, ErrZeroK, err)
+	}
+
+	tests := []struct {
+		name     string
+		m, k     uint
+		data     []byte
+		expected error
+	}{
+		{name: "zero m", m: 0, k: 4, data: data, expected: ErrZeroM},
+		{name: "zero k", m: 1000, k: 0, data: data, expected: ErrZeroK},
+		{name: "large k", m: 1000, k: MaxK + 1, data: data, expected: ErrKTooLarge},
+		{name: "short data", m: 1000, k: 4, data: data[:len(data)-8], expected: ErrDataLength},
+		{name: "long data", m: 1000, k: 4, data: append(data, 0), expected: ErrDataLength},
+		{name: "large m", m: 10000, k: 4, data: data, expected: ErrDataLength},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewValidatedReadOnlyBloomFilter(tt.m, tt.k, tt.data)
+			require.True(t, errors.Is(err, tt.expected), "unexpected error: %v", err)
+
+			_, err = NewValidatedConcurrentReadOnlyBloomFilter(tt.m, tt.k, tt.data)
+			require.True(t, errors.Is(err, tt.expected), "unexpected error: %v", err)
+		})
+	}
+}
+
+func TestDigest(t *testing.T) {
+	n1 := []byte("Bess")
+	n2 := []byte("Jane")
+	d1 := Hash(n1)
+	d2 := Hash(n2)
+
+	filters := make([]*BloomFilter, 0, 3)
+	for i := 0; i < 3; i++ {
+		f := NewBloomFilter(1000*uint(i+1), 4)
+		f.AddDigest(d1)
+		filters = append(filters, f)
+	}
+
+	for _, f := range filters {
+		require.True(t, f.Test(n1))
+		require.True(t, f.TestDigest(d1))
+		require.False(t, f.TestDigest(d2))
+
+		buf := bytes.NewBuffer(nil)
+		require.NoError(t, f.BitSet().Write(buf))
+		ro := NewReadOnlyBloomFilter(f.M(), f.K(), buf.Bytes())
+		require.True(t, ro.TestDigest(d1))
+		require.False(t, ro.TestDigest(d2))
+		cro := NewConcurrentReadOnlyBloomFilter(f.M(), f.K(), buf.Bytes())
+		require.True(t, cro.TestDigest(d1))
+		require.False(t, cro.TestDigest(d2))
+	}
+
+	f := NewBloomFilterWithHasher(1000, 4, WyhashHasher)
+	f.Add(n1)
+	require.True(t, f.TestDigest(HashWith(WyhashHasher, n1)))
+	require.False(t, f.TestDigest(HashWith(WyhashHasher, n2)))
+}
+
 func TestBasicUint32(t *testing.T) {
 	f := NewBloomFilter(1000, 4)
 	n1 := make([]byte, 4)
@@ -321,3 +418,26 @@ func BenchmarkContains100kX10BX20(b *testing.B) {
 		bf.Test(slice)
 	}
 }
+
+func BenchmarkContainsDigest1kX10kX5X10Filters(b *testing.B) {
+	var buff [8]byte
+	slice := buff[:]
+
+	b.StopTimer()
+	filters := make([]*BloomFilter, 10)
+	for i := range filters {
+		filters[i] = NewBloomFilter(10000, 5)
+		for j := 0; j < 1000; j++ {
+			endianness.PutUint64(slice, uint64(rand.Uint32()))
+			filters[i].Add(slice)
+		}
+	}
+	b.StartTimer()
+	for i := 0; i < b.N; i++ {
+		endianness.PutUint64(slice, uint64(rand.Uint32()))
+		h := Hash(slice)
+		for _, bf := range filters {
+			bf.TestDigest(h)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package bloom
+
+import "math"
+
+// estimateCount estimates the number of values added to a filter of m bits
+// and k hashes with bitsSet bits set, using the Swamidass-Baldi estimator
+// n = -(m/k) ln(1 - bitsSet/m). It is +Inf when every bit is set.
+func estimateCount(m, k, bitsSet uint64) float64 {
+	if bitsSet >= m {
+		return math.Inf(1)
+	}
+	return -float64(m) / float64(k) * math.Log1p(-float64(bitsSet)/float64(m))
+}
+
+// EstimateCount estimates the number of values added to the set from the
+// number of bits set, it is +Inf when every bit is set.
+func (b *BloomFilter) EstimateCount() float64 {
+	return estimateCount(b.m, b.k, popcount(b.bitsData()))
+}
+
+// EstimateCount estimates the number of values added to the set from the
+// number of bits set, it is +Inf when every bit is set.
+func (b *ReadOnlyBloomFilter) EstimateCount() float64 {
+	return estimateCount(b.m, b.k, popcount(b.bitsData()))
+}
+
+// EstimateCount estimates the number of values added to the set from the
+// number of bits set, it is +Inf when every bit is set.
+func (b *ConcurrentReadOnlyBloomFilter) EstimateCount() float64 {
+	return estimateCount(b.m, b.k, popcount(b.bitsData()))
+}
+
+// EstimateCount estimates the number of values added to the set from the
+// number of bits set, it is +Inf when every bit is set.
+func (b *ConcurrentBloomFilter) EstimateCount() float64 {
+	return estimateCount(b.m, b.k, popcount(b.bitsData()))
+}
+
+// EstimateCount estimates the number of values added to the set from the
+// number of bits set, it is +Inf when every bit is set.
+func (b *PersistentBloomFilter) EstimateCount() float64 {
+	return estimateCount(b.m, b.k, popcount(b.bitsData()))
+}
+
+// EstimateUnionCount estimates the number of values in the union of the
+// sets of two compatible filters from the number of bits set in the union
+// of their bits.
+func EstimateUnionCount(a, b Mergeable) (float64, error) {
+	if err := checkCompatible(a, b); err != nil {
+		return 0, err
+	}
+	union := append([]byte(nil), a.bitsData()...)
+	orBytes(union, b.bitsData())
+	return estimateCount(uint64(a.M()), uint64(a.K()), popcount(union)), nil
+}
+
+// EstimateIntersectionCount estimates the number of values in the
+// intersection of the sets of two compatible filters by inclusion-exclusion
+// of the estimated counts of each set and of their union.
+func EstimateIntersectionCount(a, b Mergeable) (float64, error) {
+	union, err := EstimateUnionCount(a, b)
+	if err != nil {
+		return 0, err
+	}
+	m, k := uint64(a.M()), uint64(a.K())
+	count := estimateCount(m, k, popcount(a.bitsData())) +
+		estimateCount(m, k, popcount(b.bitsData())) - union
+	if math.IsNaN(count) || count < 0 {
+		// Saturated filters or estimation error.
+		return 0, nil
+	}
+	return count, nil
+}
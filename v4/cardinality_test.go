@@ -0,0 +1,75 @@
+package bloom
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEstimateCount(t *testing.T) {
+	const n = 10000
+	m, k := EstimateFalsePositiveRate(n, 0.01)
+	f := NewBloomFilter(m, k)
+	c := NewConcurrentBloomFilter(m, k)
+	require.Equal(t, float64(0), f.EstimateCount())
+	for i := 0; i < n; i++ {
+		v := []byte(fmt.Sprintf("series-%d", i))
+		f.Add(v)
+		c.Add(v)
+	}
+
+	buf := bytes.NewBuffer(nil)
+	_, err := f.WriteTo(buf)
+	require.NoError(t, err)
+	ro, err := NewReadOnlyBloomFilterFromBytes(buf.Bytes(), ParseOptions{})
+	require.NoError(t, err)
+	cro, err := NewConcurrentReadOnlyBloomFilterFromBytes(buf.Bytes(), ParseOptions{})
+	require.NoError(t, err)
+
+	for _, estimate := range []float64{
+		f.EstimateCount(),
+		c.EstimateCount(),
+		ro.EstimateCount(),
+		cro.EstimateCount(),
+	} {
+		require.InEpsilon(t, n, estimate, 0.03)
+	}
+
+	// A saturated filter cannot estimate its count.
+	s := NewBloomFilter(63, 1)
+	for i := 0; i < 1000; i++ {
+		s.Add([]byte(fmt.Sprintf("series-%d", i)))
+	}
+	require.True(t, math.IsInf(s.EstimateCount(), 1))
+}
+
+func TestEstimateUnionIntersectionCount(t *testing.T) {
+	const n = 10000
+	m, k := EstimateFalsePositiveRate(3*n, 0.01)
+	a := NewBloomFilter(m, k)
+	b := NewBloomFilter(m, k)
+	// a has [0, 2n), b has [n, 3n), so the union has 3n and the
+	// intersection has n values.
+	for i := 0; i < 2*n; i++ {
+		a.Add([]byte(fmt.Sprintf("series-%d", i)))
+		b.Add([]byte(fmt.Sprintf("series-%d", i+n)))
+	}
+
+	union, err := EstimateUnionCount(a, b)
+	require.NoError(t, err)
+	require.InEpsilon(t, 3*n, union, 0.03)
+
+	intersection, err := EstimateIntersectionCount(a, b)
+	require.NoError(t, err)
+	require.InEpsilon(t, n, intersection, 0.1)
+
+	var incompatible *IncompatibleFiltersError
+	_, err = EstimateUnionCount(a, NewBloomFilter(m, k+1))
+	require.True(t, errors.As(err, &incompatible), "unexpected error: %v", err)
+	_, err = EstimateIntersectionCount(a, NewBloomFilter(m+1, k))
+	require.True(t, errors.As(err, &incompatible), "unexpected error: %v", err)
+}
@@ -0,0 +1,124 @@
+package bloom
+
+import (
+	"encoding/binary"
+	"io"
+	"sync/atomic"
+)
+
+// ConcurrentBloomFilter is a bloom filter set membership that can be
+// concurrently written to and read from by any number of writers and
+// readers. Bits are set with atomic compare and swap of 64 bit words so
+// writers do not serialize on a lock, and a value is visible to Test once
+// the Add for it has returned.
+type ConcurrentBloomFilter struct {
+	m      uint64
+	k      uint64
+	words  []uint64
+	hasher Hasher
+}
+
+// NewConcurrentBloomFilter creates a new concurrent bloom filter that can
+// represent m elements with k hashes. It is concurrent read and write safe.
+func NewConcurrentBloomFilter(m uint, k uint) *ConcurrentBloomFilter {
+	return NewConcurrentBloomFilterWithHasher(m, k, Murmur3Hasher)
+}
+
+// NewConcurrentBloomFilterWithHasher creates a new concurrent bloom filter
+// that can represent m elements with k hashes using a hasher, or
+// Murmur3Hasher if nil. It is concurrent read and write safe.
+func NewConcurrentBloomFilterWithHasher(
+	m uint,
+	k uint,
+	hasher Hasher,
+) *ConcurrentBloomFilter {
+	hasher = hasherOrDefault(hasher)
+	if m < 1 {
+		m = 1
+	}
+	if k < 1 {
+		k = 1
+	}
+	return &ConcurrentBloomFilter{
+		m:      uint64(m),
+		k:      uint64(k),
+		words:  make([]uint64, bitSetBytesLen(uint64(m))/8),
+		hasher: hasher,
+	}
+}
+
+// Add value to the set.
+func (b *ConcurrentBloomFilter) Add(value []byte) {
+	b.AddDigest(Digest(b.hasher.Sum(value)))
+}
+
+// AddDigest adds the value with a digest to the set, the digest must
+// have been computed with the hasher of the filter.
+func (b *ConcurrentBloomFilter) AddDigest(h Digest) {
+	for i := uint64(0); i < b.k; i++ {
+		loc := bloomFilterLocation(h, i, b.m)
+		addr := &b.words[loc>>6]
+		mask := uint64(1) << (loc & 63)
+		for {
+			old := atomic.LoadUint64(addr)
+			if old&mask != 0 || atomic.CompareAndSwapUint64(addr, old, old|mask) {
+				break
+			}
+		}
+	}
+}
+
+// Test if value is in the set.
+func (b *ConcurrentBloomFilter) Test(value []byte) bool {
+	return b.TestDigest(Digest(b.hasher.Sum(value)))
+}
+
+// TestDigest tests if the value with a digest is in the set, the
+// digest must have been computed with the hasher of the filter.
+func (b *ConcurrentBloomFilter) TestDigest(h Digest) bool {
+	for i := uint64(0); i < b.k; i++ {
+		loc := bloomFilterLocation(h, i, b.m)
+		if atomic.LoadUint64(&b.words[loc>>6])&(1<<(loc&63)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// M returns the m elements represented.
+func (b *ConcurrentBloomFilter) M() uint {
+	return uint(b.m)
+}
+
+// K returns the k hashes used.
+func (b *ConcurrentBloomFilter) K() uint {
+	return uint(b.k)
+}
+
+// Hasher returns the hasher used.
+func (b *ConcurrentBloomFilter) Hasher() Hasher {
+	return b.hasher
+}
+
+// ReadOnly returns a concurrent read only bloom filter backed by a copy of
+// the bits of the filter. Writers are not stopped while the copy is made,
+// so values added concurrently with the call may or may not be in the copy.
+func (b *ConcurrentBloomFilter) ReadOnly() *ConcurrentReadOnlyBloomFilter {
+	return NewConcurrentReadOnlyBloomFilterWithHasher(uint(b.m), uint(b.k),
+		b.snapshot(), b.hasher)
+}
+
+// WriteTo writes a copy of the bloom filter to a stream in the same format
+// as BloomFilter.WriteTo. Writers are not stopped while the copy is made,
+// so values added concurrently with the call may or may not be written.
+func (b *ConcurrentBloomFilter) WriteTo(w io.Writer) (int64, error) {
+	return bitSetLayout.write(w, b.m, b.k, b.hasher, b.snapshot())
+}
+
+func (b *ConcurrentBloomFilter) snapshot() []byte {
+	data := make([]byte, 8*len(b.words))
+	for i := range b.words {
+		binary.LittleEndian.PutUint64(data[8*i:], atomic.LoadUint64(&b.words[i]))
+	}
+	return data
+}
@@ -0,0 +1,91 @@
+package bloom
+
+import (
+	"bytes"
+	"runtime"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestConcurrentBloomFilter must be run with -race to detect failures
+func TestConcurrentBloomFilter(t *testing.T) {
+	gmp := runtime.GOMAXPROCS(4)
+	defer runtime.GOMAXPROCS(gmp)
+
+	const (
+		writers   = 4
+		perWriter = 1000
+	)
+	m, k := EstimateFalsePositiveRate(writers*perWriter, 0.01)
+	f := NewConcurrentBloomFilter(m, k)
+	require.Equal(t, m, f.M())
+	require.Equal(t, k, f.K())
+
+	key := func(w, i int) []byte {
+		b := make([]byte, 8)
+		endianness.PutUint64(b, uint64(w*perWriter+i))
+		return b
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < perWriter; i++ {
+				f.Add(key(w, i))
+				if !f.Test(key(w, i)) {
+					t.Errorf("%v should be in", key(w, i))
+				}
+			}
+		}(w)
+	}
+
+	// Snapshot while writers are adding.
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		_ = f.ReadOnly()
+		_, _ = f.WriteTo(bytes.NewBuffer(nil))
+	}()
+	wg.Wait()
+
+	ro := f.ReadOnly()
+	buf := bytes.NewBuffer(nil)
+	_, err := f.WriteTo(buf)
+	require.NoError(t, err)
+	var r BloomFilter
+	_, err = r.ReadFrom(buf)
+	require.NoError(t, err)
+
+	// Matches a bloom filter built from the same values.
+	b := NewBloomFilter(m, k)
+	for w := 0; w < writers; w++ {
+		for i := 0; i < perWriter; i++ {
+			b.Add(key(w, i))
+			require.True(t, ro.Test(key(w, i)))
+			require.True(t, r.Test(key(w, i)))
+		}
+	}
+	var expected, actual bytes.Buffer
+	require.NoError(t, b.BitSet().Write(&expected))
+	require.NoError(t, ro.BitSet().Write(&actual))
+	require.Equal(t, expected.Bytes(), actual.Bytes())
+	require.False(t, f.Test([]byte("Jane")))
+}
+
+func BenchmarkConcurrentAddX10kX5(b *testing.B) {
+	bf := NewConcurrentBloomFilter(10000, 5)
+	b.RunParallel(func(pb *testing.PB) {
+		var buff [8]byte
+		slice := buff[:]
+		var i uint64
+		for pb.Next() {
+			i++
+			endianness.PutUint64(slice, i)
+			bf.Add(slice)
+		}
+	})
+}
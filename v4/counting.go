@@ -0,0 +1,193 @@
+package bloom
+
+import (
+	"errors"
+	"fmt"
+)
+
+// defaultCounterWidth is the width in bits of the counters of a counting
+// bloom filter when none is specified.
+const defaultCounterWidth = 4
+
+// ErrInvalidCounterWidth is returned when creating a counting bloom filter
+// with a counter width outside of [1, 32].
+var ErrInvalidCounterWidth = errors.New("bloom: counter width must be in [1, 32]")
+
+// CountingOptions are options for creating a counting bloom filter.
+type CountingOptions struct {
+	// CounterWidth is the width in bits of each counter, from 1 to 32,
+	// or zero for 4 bit counters.
+	CounterWidth uint
+	// Hasher is the hasher used, or nil for Murmur3Hasher.
+	Hasher Hasher
+}
+
+// CountingBloomFilter is a bloom filter set membership that values can be
+// removed from. Each of the m elements is a counter rather than a bit,
+// the counters of a value are incremented when it is added and
+// decremented when it is removed. A counter that reaches its maximum
+// value saturates: it is no longer incremented or decremented, since the
+// number of values counted by it is no longer known, so values sharing
+// it can never be completely removed.
+// It cannot be concurrently read or written to, a sync.Mutex must be used
+// to guard read/write access if desired.
+type CountingBloomFilter struct {
+	m        uint64
+	k        uint64
+	max      uint32
+	counters packedArray
+	hasher   Hasher
+}
+
+// NewCountingBloomFilter creates a new counting bloom filter that can
+// represent m elements with k hashes using 4 bit counters.
+// It is not concurrent read or write safe.
+func NewCountingBloomFilter(m uint, k uint) *CountingBloomFilter {
+	b, _ := NewCountingBloomFilterWithOptions(m, k, CountingOptions{})
+	return b
+}
+
+// NewCountingBloomFilterWithOptions creates a new counting bloom filter
+// that can represent m elements with k hashes.
+// It is not concurrent read or write safe.
+func NewCountingBloomFilterWithOptions(
+	m uint,
+	k uint,
+	opts CountingOptions,
+) (*CountingBloomFilter, error) {
+	width := opts.CounterWidth
+	if width == 0 {
+		width = defaultCounterWidth
+	}
+	if width > maxPackedWidth {
+		return nil, fmt.Errorf("%w: %d", ErrInvalidCounterWidth, width)
+	}
+	hasher := opts.Hasher
+	if hasher == nil {
+		hasher = Murmur3Hasher
+	}
+	if m < 1 {
+		m = 1
+	}
+	if k < 1 {
+		k = 1
+	}
+	return &CountingBloomFilter{
+		m:        uint64(m),
+		k:        uint64(k),
+		max:      uint32(1<<width - 1),
+		counters: newPackedArray(uint64(m), width),
+		hasher:   hasher,
+	}, nil
+}
+
+// Add value to the set.
+func (b *CountingBloomFilter) Add(value []byte) {
+	b.AddDigest(Digest(b.hasher.Sum(value)))
+}
+
+// AddDigest adds the value with a digest to the set, the digest must
+// have been computed with the hasher of the filter.
+func (b *CountingBloomFilter) AddDigest(h Digest) {
+	for i := uint64(0); i < b.k; i++ {
+		loc := uint64(bloomFilterLocation(h, i, b.m))
+		if c := b.counters.get(loc); c < b.max {
+			b.counters.set(loc, c+1)
+		}
+	}
+}
+
+// Remove value from the set, it returns false and leaves the set unchanged
+// if the value is not in the set. Only values that were added should be
+// removed, removing a false positive removes other values from the set.
+func (b *CountingBloomFilter) Remove(value []byte) bool {
+	return b.RemoveDigest(Digest(b.hasher.Sum(value)))
+}
+
+// RemoveDigest removes the value with a digest from the set like Remove,
+// the digest must have been computed with the hasher of the filter.
+func (b *CountingBloomFilter) RemoveDigest(h Digest) bool {
+	if !b.TestDigest(h) {
+		return false
+	}
+	for i := uint64(0); i < b.k; i++ {
+		loc := uint64(bloomFilterLocation(h, i, b.m))
+		if c := b.counters.get(loc); c < b.max {
+			b.counters.set(loc, c-1)
+		}
+	}
+	return true
+}
+
+// Test if value is in the set.
+func (b *CountingBloomFilter) Test(value []byte) bool {
+	return b.TestDigest(Digest(b.hasher.Sum(value)))
+}
+
+// TestDigest tests if the value with a digest is in the set, the
+// digest must have been computed with the hasher of the filter.
+func (b *CountingBloomFilter) TestDigest(h Digest) bool {
+	return b.CountDigest(h) > 0
+}
+
+// Count returns an upper bound of the number of times value is in the set,
+// it is the maximum counter value if the counters of value are saturated.
+func (b *CountingBloomFilter) Count(value []byte) uint32 {
+	return b.CountDigest(Digest(b.hasher.Sum(value)))
+}
+
+// CountDigest returns an upper bound of the number of times the value with
+// a digest is in the set like Count, the digest must have been computed
+// with the hasher of the filter.
+func (b *CountingBloomFilter) CountDigest(h Digest) uint32 {
+	count := b.max
+	for i := uint64(0); i < b.k && count > 0; i++ {
+		if c := b.counters.get(uint64(bloomFilterLocation(h, i, b.m))); c < count {
+			count = c
+		}
+	}
+	return count
+}
+
+// Saturated returns the number of counters that have saturated.
+func (b *CountingBloomFilter) Saturated() uint64 {
+	var n uint64
+	for i := uint64(0); i < b.m; i++ {
+		if b.counters.get(i) == b.max {
+			n++
+		}
+	}
+	return n
+}
+
+// M returns the m elements represented.
+func (b *CountingBloomFilter) M() uint {
+	return uint(b.m)
+}
+
+// K returns the k hashes used.
+func (b *CountingBloomFilter) K() uint {
+	return uint(b.k)
+}
+
+// CounterWidth returns the width in bits of each counter.
+func (b *CountingBloomFilter) CounterWidth() uint {
+	return uint(b.counters.width)
+}
+
+// Hasher returns the hasher used.
+func (b *CountingBloomFilter) Hasher() Hasher {
+	return b.hasher
+}
+
+// BloomFilter returns a new bloom filter with the bits set for each
+// non-zero counter, which can be serialized with BloomFilter.WriteTo.
+func (b *CountingBloomFilter) BloomFilter() *BloomFilter {
+	f := NewBloomFilterWithHasher(uint(b.m), uint(b.k), b.hasher)
+	for i := uint64(0); i < b.m; i++ {
+		if b.counters.get(i) > 0 {
+			f.set.Set(uint(i))
+		}
+	}
+	return f
+}
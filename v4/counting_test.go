@@ -0,0 +1,88 @@
+package bloom
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCountingBloomFilter(t *testing.T) {
+	f := NewCountingBloomFilter(1000, 4)
+	require.Equal(t, uint(4), f.CounterWidth())
+	n1 := []byte("Bess")
+	n2 := []byte("Jane")
+	n3 := []byte("Emma")
+
+	f.Add(n1)
+	f.Add(n1)
+	f.Add(n3)
+	require.True(t, f.Test(n1))
+	require.False(t, f.Test(n2))
+	require.True(t, f.Test(n3))
+	require.Equal(t, uint32(2), f.Count(n1))
+	require.Equal(t, uint32(0), f.Count(n2))
+
+	require.False(t, f.Remove(n2))
+	require.True(t, f.Remove(n1))
+	require.True(t, f.Test(n1))
+	require.True(t, f.Remove(n1))
+	require.False(t, f.Test(n1))
+	require.True(t, f.Test(n3))
+	require.True(t, f.Remove(n3))
+	require.False(t, f.Test(n3))
+	require.Equal(t, uint64(0), f.Saturated())
+}
+
+func TestCountingBloomFilterSaturation(t *testing.T) {
+	f, err := NewCountingBloomFilterWithOptions(1000, 4, CountingOptions{
+		CounterWidth: 2,
+		Hasher:       WyhashHasher,
+	})
+	require.NoError(t, err)
+	require.Equal(t, WyhashHasher, f.Hasher())
+
+	n1 := []byte("Bess")
+	for i := 0; i < 5; i++ {
+		f.Add(n1)
+	}
+	require.Equal(t, uint32(3), f.Count(n1))
+	require.Equal(t, uint64(4), f.Saturated())
+
+	// Saturated counters are not decremented.
+	for i := 0; i < 5; i++ {
+		require.True(t, f.Remove(n1))
+	}
+	require.True(t, f.Test(n1))
+
+	_, err = NewCountingBloomFilterWithOptions(1000, 4, CountingOptions{CounterWidth: 33})
+	require.True(t, errors.Is(err, ErrInvalidCounterWidth), "unexpected error: %v", err)
+}
+
+func TestCountingBloomFilterExport(t *testing.T) {
+	f := NewCountingBloomFilter(10000, 5)
+	b := NewBloomFilter(10000, 5)
+	for i := 0; i < 1000; i++ {
+		v := []byte(fmt.Sprintf("series-%d", i))
+		f.Add(v)
+		b.Add(v)
+	}
+
+	exported := f.BloomFilter()
+	require.Equal(t, f.M(), exported.M())
+	require.Equal(t, f.K(), exported.K())
+	var expected, actual bytes.Buffer
+	require.NoError(t, b.BitSet().Write(&expected))
+	require.NoError(t, exported.BitSet().Write(&actual))
+	require.Equal(t, expected.Bytes(), actual.Bytes())
+
+	for i := 0; i < 1000; i += 2 {
+		require.True(t, f.Remove([]byte(fmt.Sprintf("series-%d", i))))
+	}
+	exported = f.BloomFilter()
+	for i := 1; i < 1000; i += 2 {
+		require.True(t, exported.Test([]byte(fmt.Sprintf("series-%d", i))))
+	}
+}
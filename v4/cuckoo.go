@@ -0,0 +1,595 @@
+package bloom
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+const (
+	// cuckooHeaderMagic identifies a serialized cuckoo filter, it is "M3CF"
+	// when read as little endian bytes.
+	cuckooHeaderMagic uint32 = 0x4643334d
+	// cuckooHeaderLen is the length in bytes of a serialized cuckoo filter
+	// header.
+	cuckooHeaderLen = 48
+	// cuckooChecksumOffset is the offset of the checksum in a serialized
+	// cuckoo filter header, the bytes before it and the payload are covered
+	// by it.
+	cuckooChecksumOffset = 40
+
+	defaultFingerprintBits = 16
+	defaultBucketSize      = 4
+	maxBucketSize          = 16
+	// maxCuckooLoadFactor is the load factor a cuckoo filter is sized for,
+	// with buckets of 4 fingerprints inserts rarely fail below 95% load.
+	maxCuckooLoadFactor = 0.95
+	// maxCuckooKicks is the number of fingerprints relocated before an
+	// insert gives up.
+	maxCuckooKicks = 500
+)
+
+var (
+	// ErrCuckooFilterFull is returned when adding a value to a cuckoo
+	// filter that is full.
+	ErrCuckooFilterFull = errors.New("bloom: cuckoo filter is full")
+	// ErrInvalidFingerprintBits is returned when creating a cuckoo filter
+	// with fingerprints of a width outside of [1, 32] bits.
+	ErrInvalidFingerprintBits = errors.New("bloom: fingerprint bits must be in [1, 32]")
+	// ErrInvalidBucketSize is returned when creating a cuckoo filter with
+	// buckets of a size outside of [1, 16].
+	ErrInvalidBucketSize = errors.New("bloom: bucket size must be in [1, 16]")
+)
+
+// CuckooOptions are options for creating a cuckoo filter.
+type CuckooOptions struct {
+	// FingerprintBits is the width in bits of the fingerprint stored for
+	// each value, from 1 to 32, or zero for 16 bit fingerprints.
+	FingerprintBits uint
+	// BucketSize is the number of fingerprints in each bucket, from 1 to
+	// 16, or zero for buckets of 4 fingerprints.
+	BucketSize uint
+	// Hasher is the hasher used, or nil for Murmur3Hasher.
+	Hasher Hasher
+}
+
+func (o CuckooOptions) withDefaults() (CuckooOptions, error) {
+	if o.FingerprintBits == 0 {
+		o.FingerprintBits = defaultFingerprintBits
+	}
+	if o.FingerprintBits > maxPackedWidth {
+		return CuckooOptions{}, fmt.Errorf("%w: %d", ErrInvalidFingerprintBits, o.FingerprintBits)
+	}
+	if o.BucketSize == 0 {
+		o.BucketSize = defaultBucketSize
+	}
+	if o.BucketSize > maxBucketSize {
+		return CuckooOptions{}, fmt.Errorf("%w: %d", ErrInvalidBucketSize, o.BucketSize)
+	}
+	if o.Hasher == nil {
+		o.Hasher = Murmur3Hasher
+	}
+	return o, nil
+}
+
+// cuckooTable is the buckets of fingerprints of a cuckoo filter, along
+// with a victim fingerprint that could not be placed in either of its
+// buckets. A fingerprint of zero is an empty slot.
+//
+// Buckets of 4 fingerprints of more than 4 bits are semi-sorted as
+// described by Fan et al.: the low 4 bits of the fingerprints of a bucket
+// are sorted and stored as the 12 bit index of their sorted tuple, of
+// which there are only 3876, followed by the remaining bits of each
+// fingerprint in the same order. This saves a bit per fingerprint.
+type cuckooTable struct {
+	buckets    uint64
+	bucketSize uint64
+	width      uint
+	semiSorted bool
+	// data is the indexes of the sorted low bits of each bucket if the
+	// buckets are semi-sorted, followed by the fingerprints or their
+	// remaining bits.
+	data         []byte
+	indexes      packedArray
+	fingerprints packedArray
+	victim       uint32
+	victimBucket uint64
+	hasher       Hasher
+}
+
+const (
+	semiSortBucketSize = 4
+	semiSortLowBits    = 4
+	semiSortIndexBits  = 12
+)
+
+// semiSortTuples holds the sorted tuples of 4 values of 4 bits, packed
+// into the nibbles of each entry, and semiSortIndexes holds the index of
+// each in semiSortTuples. Indexes past the last tuple, which only corrupt
+// data holds, map to the tuple of zeros.
+var semiSortTuples, semiSortIndexes = newSemiSortTables()
+
+func newSemiSortTables() ([]uint16, []uint16) {
+	tuples := make([]uint16, 0, 1<<semiSortIndexBits)
+	indexes := make([]uint16, 1<<(semiSortBucketSize*semiSortLowBits))
+	for a := 0; a < 16; a++ {
+		for b := a; b < 16; b++ {
+			for c := b; c < 16; c++ {
+				for d := c; d < 16; d++ {
+					tuple := uint16(a | b<<4 | c<<8 | d<<12)
+					indexes[tuple] = uint16(len(tuples))
+					tuples = append(tuples, tuple)
+				}
+			}
+		}
+	}
+	return tuples[:cap(tuples)], indexes
+}
+
+// newCuckooTable returns a table of buckets without its fingerprints,
+// they must be set with setData.
+func newCuckooTable(buckets, bucketSize uint64, width uint, hasher Hasher) cuckooTable {
+	return cuckooTable{
+		buckets:    buckets,
+		bucketSize: bucketSize,
+		width:      width,
+		semiSorted: bucketSize == semiSortBucketSize && width > semiSortLowBits,
+		hasher:     hasher,
+	}
+}
+
+// dataLen returns the length in bytes of the fingerprints of the table.
+func (t *cuckooTable) dataLen() uint64 {
+	if !t.semiSorted {
+		return packedArrayBytesLen(t.buckets*t.bucketSize, t.width)
+	}
+	return packedArrayBytesLen(t.buckets, semiSortIndexBits) +
+		packedArrayBytesLen(t.buckets*t.bucketSize, t.width-semiSortLowBits)
+}
+
+// setData sets the fingerprints of the table to data, which must be
+// dataLen bytes long.
+func (t *cuckooTable) setData(data []byte) {
+	t.data = data
+	if !t.semiSorted {
+		t.fingerprints = newPackedArrayFromBytes(data, t.width)
+		return
+	}
+	n := packedArrayBytesLen(t.buckets, semiSortIndexBits)
+	t.indexes = newPackedArrayFromBytes(data[:n], semiSortIndexBits)
+	t.fingerprints = newPackedArrayFromBytes(data[n:], t.width-semiSortLowBits)
+}
+
+// bucket returns the fingerprints of a bucket.
+func (t *cuckooTable) bucket(bucket uint64) [maxBucketSize]uint32 {
+	var fps [maxBucketSize]uint32
+	first := bucket * t.bucketSize
+	if !t.semiSorted {
+		for i := uint64(0); i < t.bucketSize; i++ {
+			fps[i] = t.fingerprints.get(first + i)
+		}
+		return fps
+	}
+	tuple := semiSortTuples[t.indexes.get(bucket)]
+	for i := uint64(0); i < semiSortBucketSize; i++ {
+		fps[i] = t.fingerprints.get(first+i)<<semiSortLowBits |
+			uint32(tuple>>(i*semiSortLowBits))&(1<<semiSortLowBits-1)
+	}
+	return fps
+}
+
+// setBucket sets the fingerprints of a bucket, in any order.
+func (t *cuckooTable) setBucket(bucket uint64, fps [maxBucketSize]uint32) {
+	first := bucket * t.bucketSize
+	if !t.semiSorted {
+		for i := uint64(0); i < t.bucketSize; i++ {
+			t.fingerprints.set(first+i, fps[i])
+		}
+		return
+	}
+	const lowMask = 1<<semiSortLowBits - 1
+	for i := 1; i < semiSortBucketSize; i++ {
+		for j := i; j > 0 && fps[j]&lowMask < fps[j-1]&lowMask; j-- {
+			fps[j], fps[j-1] = fps[j-1], fps[j]
+		}
+	}
+	var tuple uint16
+	for i := uint64(0); i < semiSortBucketSize; i++ {
+		tuple |= uint16(fps[i]&lowMask) << (i * semiSortLowBits)
+		t.fingerprints.set(first+i, fps[i]>>semiSortLowBits)
+	}
+	t.indexes.set(bucket, uint32(semiSortIndexes[tuple]))
+}
+
+// locate returns the fingerprint of a digest and its first bucket.
+func (t *cuckooTable) locate(h Digest) (uint32, uint64) {
+	fp := uint32(h[1] & (1<<t.width - 1))
+	if fp == 0 {
+		fp = 1
+	}
+	return fp, h[0] & (t.buckets - 1)
+}
+
+// alt returns the other bucket of a fingerprint in a bucket, it only
+// depends on the fingerprint so that either bucket can be found from the
+// other when relocating the fingerprint.
+func (t *cuckooTable) alt(bucket uint64, fp uint32) uint64 {
+	return (bucket ^ splitmix64(uint64(fp))) & (t.buckets - 1)
+}
+
+func (t *cuckooTable) contains(bucket uint64, fp uint32) bool {
+	fps := t.bucket(bucket)
+	for i := uint64(0); i < t.bucketSize; i++ {
+		if fps[i] == fp {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *cuckooTable) test(h Digest) bool {
+	fp, i1 := t.locate(h)
+	if t.contains(i1, fp) || t.contains(t.alt(i1, fp), fp) {
+		return true
+	}
+	return t.victim == fp &&
+		(t.victimBucket == i1 || t.victimBucket == t.alt(i1, fp))
+}
+
+// CuckooFilter is a cuckoo filter set membership that values can be
+// removed from, as described by Fan et al. in "Cuckoo Filter: Practically
+// Better Than Bloom". A fingerprint of each value is stored in one of two
+// buckets, relocating other fingerprints to their alternate bucket to make
+// room if needed. The false positive rate is about 2*BucketSize/2^f with f
+// bit fingerprints.
+//
+// Buckets of 4 fingerprints of more than 4 bits are semi-sorted, saving a
+// bit per fingerprint, so that at 95% load a cuckoo filter uses fewer bits
+// per value than an optimally sized BloomFilter for false positive rates
+// below about 3%, 8 bit fingerprints or more.
+//
+// Only values that were added should be removed, removing any other value
+// may remove the fingerprint of a different value that shares it. A value
+// can be added at most 2*BucketSize times.
+// It cannot be concurrently read or written to, a sync.Mutex must be used
+// to guard read/write access if desired.
+type CuckooFilter struct {
+	cuckooTable
+	count uint64
+	seed  uint64
+}
+
+// NewCuckooFilter creates a new cuckoo filter that can hold capacity
+// values with 16 bit fingerprints and buckets of 4 fingerprints.
+// It is not concurrent read or write safe.
+func NewCuckooFilter(capacity uint) *CuckooFilter {
+	b, _ := NewCuckooFilterWithOptions(capacity, CuckooOptions{})
+	return b
+}
+
+// NewCuckooFilterWithOptions creates a new cuckoo filter that can hold
+// capacity values. It is not concurrent read or write safe.
+func NewCuckooFilterWithOptions(
+	capacity uint,
+	opts CuckooOptions,
+) (*CuckooFilter, error) {
+	opts, err := opts.withDefaults()
+	if err != nil {
+		return nil, err
+	}
+	// The number of buckets is a power of two so that the alternate
+	// bucket of a fingerprint is its own inverse.
+	buckets := uint64(1)
+	for float64(buckets*uint64(opts.BucketSize))*maxCuckooLoadFactor < float64(capacity) {
+		buckets <<= 1
+	}
+	t := newCuckooTable(buckets, uint64(opts.BucketSize), opts.FingerprintBits, opts.Hasher)
+	t.setData(make([]byte, t.dataLen()))
+	return &CuckooFilter{cuckooTable: t}, nil
+}
+
+// Add value to the set, it returns ErrCuckooFilterFull without adding the
+// value if the filter is full.
+func (b *CuckooFilter) Add(value []byte) error {
+	return b.AddDigest(Digest(b.hasher.Sum(value)))
+}
+
+// AddDigest adds the value with a digest to the set, the digest must have
+// been computed with the hasher of the filter. It returns
+// ErrCuckooFilterFull without adding the value if the filter is full.
+func (b *CuckooFilter) AddDigest(h Digest) error {
+	if b.victim != 0 {
+		return ErrCuckooFilterFull
+	}
+	fp, i1 := b.locate(h)
+	b.insert(i1, fp)
+	b.count++
+	return nil
+}
+
+// insert inserts a fingerprint into a bucket or its alternate, relocating
+// fingerprints if both are full. A fingerprint that cannot be placed is
+// kept as the victim, so nothing is lost but the filter is then full.
+func (b *CuckooFilter) insert(bucket uint64, fp uint32) {
+	if b.insertInto(bucket, fp) {
+		return
+	}
+	bucket = b.alt(bucket, fp)
+	for n := 0; n < maxCuckooKicks; n++ {
+		if b.insertInto(bucket, fp) {
+			return
+		}
+		b.seed += 0x9e3779b97f4a7c15
+		fps := b.bucket(bucket)
+		slot := splitmix64(b.seed) % b.bucketSize
+		fps[slot], fp = fp, fps[slot]
+		b.setBucket(bucket, fps)
+		bucket = b.alt(bucket, fp)
+	}
+	b.victim = fp
+	b.victimBucket = bucket
+}
+
+func (b *CuckooFilter) insertInto(bucket uint64, fp uint32) bool {
+	return b.replace(bucket, 0, fp)
+}
+
+// Remove value from the set, returning whether the value may have been in
+// the set.
+func (b *CuckooFilter) Remove(value []byte) bool {
+	return b.RemoveDigest(Digest(b.hasher.Sum(value)))
+}
+
+// RemoveDigest removes the value with a digest from the set, the digest
+// must have been computed with the hasher of the filter. It returns
+// whether the value may have been in the set.
+func (b *CuckooFilter) RemoveDigest(h Digest) bool {
+	fp, i1 := b.locate(h)
+	i2 := b.alt(i1, fp)
+	if b.victim == fp && (b.victimBucket == i1 || b.victimBucket == i2) {
+		b.victim = 0
+		b.count--
+		return true
+	}
+	if !b.removeFrom(i1, fp) && !b.removeFrom(i2, fp) {
+		return false
+	}
+	b.count--
+	if b.victim != 0 {
+		// Removing a fingerprint may have made room for the victim.
+		victim, bucket := b.victim, b.victimBucket
+		b.victim = 0
+		b.insert(bucket, victim)
+	}
+	return true
+}
+
+func (b *CuckooFilter) removeFrom(bucket uint64, fp uint32) bool {
+	return b.replace(bucket, fp, 0)
+}
+
+// replace replaces a fingerprint in a bucket with another, returning
+// whether the bucket held it.
+func (b *CuckooFilter) replace(bucket uint64, old, fp uint32) bool {
+	fps := b.bucket(bucket)
+	for i := uint64(0); i < b.bucketSize; i++ {
+		if fps[i] == old {
+			fps[i] = fp
+			b.setBucket(bucket, fps)
+			return true
+		}
+	}
+	return false
+}
+
+// Test if value is in the set.
+func (b *CuckooFilter) Test(value []byte) bool {
+	return b.test(Digest(b.hasher.Sum(value)))
+}
+
+// TestDigest tests if the value with a digest is in the set, the digest
+// must have been computed with the hasher of the filter.
+func (b *CuckooFilter) TestDigest(h Digest) bool {
+	return b.test(h)
+}
+
+// Count returns the number of values in the set.
+func (b *CuckooFilter) Count() uint64 {
+	return b.count
+}
+
+// Capacity returns the number of fingerprints the filter has room for,
+// inserts may fail before the filter holds this many values.
+func (b *CuckooFilter) Capacity() uint64 {
+	return b.buckets * b.bucketSize
+}
+
+// FingerprintBits returns the width in bits of the fingerprints.
+func (b *CuckooFilter) FingerprintBits() uint {
+	return b.width
+}
+
+// BucketSize returns the number of fingerprints in each bucket.
+func (b *CuckooFilter) BucketSize() uint {
+	return uint(b.bucketSize)
+}
+
+// Hasher returns the hasher used.
+func (b *CuckooFilter) Hasher() Hasher {
+	return b.hasher
+}
+
+// WriteTo writes the cuckoo filter to a stream with a header describing
+// its buckets and the hash scheme.
+func (b *CuckooFilter) WriteTo(w io.Writer) (int64, error) {
+	var buf [cuckooHeaderLen]byte
+	b.encodeHeader(buf[:], b.count)
+	cw := &countingWriter{w: w}
+	if _, err := cw.Write(buf[:]); err != nil {
+		return cw.n, err
+	}
+	_, err := cw.Write(b.data)
+	return cw.n, err
+}
+
+// ReadFrom reads a cuckoo filter previously written with WriteTo from a
+// stream, replacing the contents of the cuckoo filter. If the filter has
+// a hasher the stream must have been written with the same hasher.
+func (b *CuckooFilter) ReadFrom(r io.Reader) (int64, error) {
+	var buf [cuckooHeaderLen]byte
+	n, err := io.ReadFull(r, buf[:])
+	read := int64(n)
+	if err != nil {
+		return read, readErr(err)
+	}
+	t, count, payloadLen, err := parseCuckooHeader(buf[:], b.hasher)
+	if err != nil {
+		return read, err
+	}
+	payload, payloadRead, err := readPayload(r, payloadLen)
+	read += payloadRead
+	if err != nil {
+		return read, err
+	}
+	sum := storedChecksum{
+		header:  buf[:cuckooChecksumOffset],
+		payload: payload,
+		value:   binary.LittleEndian.Uint32(buf[40:44]),
+	}
+	if err := sum.verify(); err != nil {
+		return read, err
+	}
+	t.setData(payload)
+	b.cuckooTable = t
+	b.count = count
+	return read, nil
+}
+
+// ReadOnlyCuckooFilter is a read only cuckoo filter set membership backed
+// by a byte slice, this means it can be used with a mmap'd bytes ref. It
+// holds no mutable state so it can be concurrently read from by any number
+// of readers.
+type ReadOnlyCuckooFilter struct {
+	cuckooTable
+	count    uint64
+	checksum *storedChecksum
+}
+
+// NewReadOnlyCuckooFilterFromBytes returns a new read only cuckoo filter
+// from data previously written with CuckooFilter.WriteTo, the fingerprints
+// are not copied so data can be a mmap'd bytes ref.
+func NewReadOnlyCuckooFilterFromBytes(
+	data []byte,
+	opts ParseOptions,
+) (*ReadOnlyCuckooFilter, error) {
+	if len(data) < cuckooHeaderLen {
+		return nil, ErrTruncated
+	}
+	t, count, payloadLen, err := parseCuckooHeader(data, opts.Hasher)
+	if err != nil {
+		return nil, err
+	}
+	if uint64(len(data)-cuckooHeaderLen) < payloadLen {
+		return nil, ErrTruncated
+	}
+	payload := data[cuckooHeaderLen : cuckooHeaderLen+int(payloadLen)]
+	sum := &storedChecksum{
+		header:  data[:cuckooChecksumOffset],
+		payload: payload,
+		value:   binary.LittleEndian.Uint32(data[40:44]),
+	}
+	if opts.VerifyChecksum {
+		if err := sum.verify(); err != nil {
+			return nil, err
+		}
+	}
+	t.setData(payload)
+	return &ReadOnlyCuckooFilter{
+		cuckooTable: t,
+		count:       count,
+		checksum:    sum,
+	}, nil
+}
+
+// Test if value is in the set.
+func (b *ReadOnlyCuckooFilter) Test(value []byte) bool {
+	return b.test(Digest(b.hasher.Sum(value)))
+}
+
+// TestDigest tests if the value with a digest is in the set, the digest
+// must have been computed with the hasher of the filter.
+func (b *ReadOnlyCuckooFilter) TestDigest(h Digest) bool {
+	return b.test(h)
+}
+
+// Count returns the number of values in the set.
+func (b *ReadOnlyCuckooFilter) Count() uint64 {
+	return b.count
+}
+
+// Hasher returns the hasher used.
+func (b *ReadOnlyCuckooFilter) Hasher() Hasher {
+	return b.hasher
+}
+
+// Verify verifies the fingerprints of the filter against the checksum
+// stored when it was serialized.
+func (b *ReadOnlyCuckooFilter) Verify() error {
+	return b.checksum.verify()
+}
+
+// encodeHeader encodes the header of the table, the checksum covers the
+// header and the fingerprints.
+func (t *cuckooTable) encodeHeader(buf []byte, count uint64) {
+	binary.LittleEndian.PutUint32(buf[0:4], cuckooHeaderMagic)
+	binary.LittleEndian.PutUint16(buf[4:6], formatVersion)
+	binary.LittleEndian.PutUint16(buf[6:8], uint16(t.hasher.Scheme()))
+	binary.LittleEndian.PutUint64(buf[8:16], t.buckets)
+	binary.LittleEndian.PutUint64(buf[16:24], count)
+	binary.LittleEndian.PutUint16(buf[24:26], uint16(t.bucketSize))
+	binary.LittleEndian.PutUint16(buf[26:28], uint16(t.width))
+	binary.LittleEndian.PutUint32(buf[28:32], t.victim)
+	binary.LittleEndian.PutUint64(buf[32:40], t.victimBucket)
+	binary.LittleEndian.PutUint32(buf[40:44],
+		checksum(buf[:cuckooChecksumOffset], t.data))
+	binary.LittleEndian.PutUint32(buf[44:48], 0)
+}
+
+// parseCuckooHeader parses the header of a cuckoo filter, returning its
+// table without fingerprints, its count and the length of its
+// fingerprints.
+func parseCuckooHeader(
+	data []byte,
+	expected Hasher,
+) (cuckooTable, uint64, uint64, error) {
+	if binary.LittleEndian.Uint32(data[0:4]) != cuckooHeaderMagic {
+		return cuckooTable{}, 0, 0, ErrInvalidMagic
+	}
+	if v := binary.LittleEndian.Uint16(data[4:6]); v != formatVersion {
+		return cuckooTable{}, 0, 0, fmt.Errorf("%w: %d", ErrUnsupportedVersion, v)
+	}
+	hasher, err := resolveHasher(HashScheme(binary.LittleEndian.Uint16(data[6:8])), expected)
+	if err != nil {
+		return cuckooTable{}, 0, 0, err
+	}
+	bucketSize := uint64(binary.LittleEndian.Uint16(data[24:26]))
+	if bucketSize == 0 || bucketSize > maxBucketSize {
+		return cuckooTable{}, 0, 0, fmt.Errorf("%w: %d", ErrInvalidBucketSize, bucketSize)
+	}
+	width := uint(binary.LittleEndian.Uint16(data[26:28]))
+	if width == 0 || width > maxPackedWidth {
+		return cuckooTable{}, 0, 0, fmt.Errorf("%w: %d", ErrInvalidFingerprintBits, width)
+	}
+	buckets := binary.LittleEndian.Uint64(data[8:16])
+	if buckets == 0 || buckets&(buckets-1) != 0 ||
+		buckets > math.MaxUint64/(bucketSize*uint64(width)) {
+		return cuckooTable{}, 0, 0, fmt.Errorf("%w: %d buckets", ErrPayloadLength, buckets)
+	}
+	t := newCuckooTable(buckets, bucketSize, width, hasher)
+	t.victim = binary.LittleEndian.Uint32(data[28:32])
+	t.victimBucket = binary.LittleEndian.Uint64(data[32:40])
+	count := binary.LittleEndian.Uint64(data[16:24])
+	return t, count, t.dataLen(), nil
+}
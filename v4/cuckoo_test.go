@@ -0,0 +1,296 @@
+package bloom
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCuckooFilter(t *testing.T) {
+	f := NewCuckooFilter(1000)
+	n1 := []byte("Bess")
+	n2 := []byte("Jane")
+	n3 := []byte("Emma")
+	require.NoError(t, f.Add(n1))
+	require.NoError(t, f.Add(n3))
+
+	require.Equal(t, uint(16), f.FingerprintBits())
+	require.Equal(t, uint(4), f.BucketSize())
+	require.Equal(t, uint64(2048), f.Capacity())
+	require.Equal(t, uint64(2), f.Count())
+	require.True(t, f.Test(n1))
+	require.False(t, f.Test(n2))
+	require.True(t, f.Test(n3))
+
+	require.True(t, f.Remove(n1))
+	require.False(t, f.Test(n1))
+	require.True(t, f.Test(n3))
+	require.False(t, f.Remove(n2))
+	require.Equal(t, uint64(1), f.Count())
+}
+
+func TestCuckooFilterDuplicates(t *testing.T) {
+	f := NewCuckooFilter(100)
+	n1 := []byte("Bess")
+	require.NoError(t, f.Add(n1))
+	require.NoError(t, f.Add(n1))
+
+	// Each add stores a fingerprint so each must be removed.
+	require.True(t, f.Remove(n1))
+	require.True(t, f.Test(n1))
+	require.True(t, f.Remove(n1))
+	require.False(t, f.Test(n1))
+}
+
+func TestCuckooFilterFull(t *testing.T) {
+	f, err := NewCuckooFilterWithOptions(1000, CuckooOptions{FingerprintBits: 12})
+	require.NoError(t, err)
+
+	var (
+		buff  [8]byte
+		added int
+	)
+	for i := 0; ; i++ {
+		endianness.PutUint64(buff[:], uint64(i))
+		if err := f.Add(buff[:]); err != nil {
+			require.Equal(t, ErrCuckooFilterFull, err)
+			break
+		}
+		added++
+	}
+	require.Equal(t, uint64(added), f.Count())
+	require.True(t, float64(added) > 0.9*float64(f.Capacity()),
+		"added %d of %d", added, f.Capacity())
+
+	// No value is lost when the filter fills.
+	for i := 0; i < added; i++ {
+		endianness.PutUint64(buff[:], uint64(i))
+		require.True(t, f.Test(buff[:]))
+	}
+
+	// Removing values makes room again.
+	for i := 0; i < 10; i++ {
+		endianness.PutUint64(buff[:], uint64(i))
+		require.True(t, f.Remove(buff[:]))
+	}
+	endianness.PutUint64(buff[:], uint64(added))
+	require.NoError(t, f.Add(buff[:]))
+	for i := 10; i <= added; i++ {
+		endianness.PutUint64(buff[:], uint64(i))
+		require.True(t, f.Test(buff[:]))
+	}
+}
+
+func TestCuckooFilterFalsePositiveRate(t *testing.T) {
+	const n = 10000
+	f, err := NewCuckooFilterWithOptions(n, CuckooOptions{FingerprintBits: 8})
+	require.NoError(t, err)
+	var buff [8]byte
+	for i := 0; i < n; i++ {
+		endianness.PutUint64(buff[:], uint64(i))
+		require.NoError(t, f.Add(buff[:]))
+	}
+
+	var fp int
+	for i := n; i < 11*n; i++ {
+		endianness.PutUint64(buff[:], uint64(i))
+		if f.Test(buff[:]) {
+			fp++
+		}
+	}
+	// At most 2*BucketSize fingerprints are compared for each test.
+	require.True(t, float64(fp)/(10*n) < 8.0/256, "false positives: %d", fp)
+}
+
+func TestCuckooFilterSemiSortedBuckets(t *testing.T) {
+	require.Equal(t, 3876, int(semiSortIndexes[0xffff])+1)
+
+	f, err := NewCuckooFilterWithOptions(100, CuckooOptions{FingerprintBits: 9})
+	require.NoError(t, err)
+	require.True(t, f.semiSorted)
+	// A bucket takes 12 bits for its low bits and 5 bits per fingerprint.
+	require.Equal(t, (f.buckets*12+7)/8+(f.buckets*4*5+7)/8, uint64(len(f.data)))
+
+	// Fingerprints are read back from a bucket in the order of their low
+	// bits, whatever the order they were set in.
+	fps := [maxBucketSize]uint32{0x1f3, 0, 0x0a1, 0x1f3}
+	f.setBucket(3, fps)
+	require.Equal(t, [maxBucketSize]uint32{0, 0x0a1, 0x1f3, 0x1f3}, f.bucket(3))
+	require.True(t, f.contains(3, 0x0a1))
+	require.False(t, f.contains(3, 0x1a1))
+	require.Equal(t, [maxBucketSize]uint32{}, f.bucket(2))
+
+	for _, opts := range []CuckooOptions{
+		{FingerprintBits: 4},
+		{FingerprintBits: 9, BucketSize: 2},
+	} {
+		f, err := NewCuckooFilterWithOptions(100, opts)
+		require.NoError(t, err)
+		require.False(t, f.semiSorted)
+		require.NoError(t, f.Add([]byte("Bess")))
+		require.True(t, f.Test([]byte("Bess")))
+		require.True(t, f.Remove([]byte("Bess")))
+		require.False(t, f.Test([]byte("Bess")))
+	}
+
+	// An index past the last sorted tuple, which only corrupt data holds,
+	// reads as an empty bucket.
+	buf := bytes.NewBuffer(nil)
+	_, err = f.WriteTo(buf)
+	require.NoError(t, err)
+	data := buf.Bytes()
+	data[cuckooHeaderLen] = 0xff
+	data[cuckooHeaderLen+1] |= 0x0f
+	ro, err := NewReadOnlyCuckooFilterFromBytes(data, ParseOptions{})
+	require.NoError(t, err)
+	require.Equal(t, [maxBucketSize]uint32{}, ro.bucket(0))
+}
+
+func TestCuckooFilterSpaceVersusBloomFilter(t *testing.T) {
+	// bitsPerValue returns the bits per value of a full cuckoo filter with
+	// f bit fingerprints, its false positive rate and the bits per value of
+	// a bloom filter with that false positive rate.
+	bitsPerValue := func(f uint) (float64, float64, float64) {
+		c, err := NewCuckooFilterWithOptions(20000, CuckooOptions{FingerprintBits: f})
+		require.NoError(t, err)
+		var (
+			buff [8]byte
+			n    int
+		)
+		for ; uint64(n) < c.Capacity(); n++ {
+			endianness.PutUint64(buff[:], uint64(n))
+			if c.Add(buff[:]) != nil {
+				break
+			}
+		}
+		var fp int
+		const tests = 200000
+		for i := 0; i < tests; i++ {
+			endianness.PutUint64(buff[:], uint64(1<<40+i))
+			if c.Test(buff[:]) {
+				fp++
+			}
+		}
+		p := float64(fp) / tests
+		return float64(8*len(c.data)) / float64(n), p,
+			-math.Log(p) / (math.Ln2 * math.Ln2)
+	}
+
+	// The crossover documented on CuckooFilter, with semi-sorted buckets
+	// 8 bit fingerprints and a false positive rate of about 3% take fewer
+	// bits per value than a bloom filter.
+	cuckoo, p, bloom := bitsPerValue(8)
+	require.True(t, p > 0.025 && p < 0.035, "false positive rate: %f", p)
+	require.True(t, cuckoo < bloom, "f=8: cuckoo %.2f, bloom %.2f", cuckoo, bloom)
+	for _, f := range []uint{9, 12, 16} {
+		cuckoo, _, bloom := bitsPerValue(f)
+		require.True(t, cuckoo < bloom, "f=%d: cuckoo %.2f, bloom %.2f", f, cuckoo, bloom)
+	}
+}
+
+func TestCuckooFilterOptions(t *testing.T) {
+	_, err := NewCuckooFilterWithOptions(100, CuckooOptions{FingerprintBits: 33})
+	require.True(t, errors.Is(err, ErrInvalidFingerprintBits))
+	_, err = NewCuckooFilterWithOptions(100, CuckooOptions{BucketSize: 17})
+	require.True(t, errors.Is(err, ErrInvalidBucketSize))
+
+	f, err := NewCuckooFilterWithOptions(100, CuckooOptions{
+		FingerprintBits: 7,
+		BucketSize:      2,
+		Hasher:          XXHash64Hasher,
+	})
+	require.NoError(t, err)
+	require.Equal(t, uint(7), f.FingerprintBits())
+	require.Equal(t, uint(2), f.BucketSize())
+	require.Equal(t, uint64(128), f.Capacity())
+	require.Equal(t, HashSchemeXXHash64, f.Hasher().Scheme())
+}
+
+func TestCuckooFilterWriteToReadFrom(t *testing.T) {
+	f, err := NewCuckooFilterWithOptions(1000, CuckooOptions{
+		FingerprintBits: 13,
+		Hasher:          WyhashHasher,
+	})
+	require.NoError(t, err)
+	n1 := []byte("Bess")
+	n2 := []byte("Jane")
+	require.NoError(t, f.Add(n1))
+
+	buf := bytes.NewBuffer(nil)
+	written, err := f.WriteTo(buf)
+	require.NoError(t, err)
+	require.Equal(t, int64(buf.Len()), written)
+
+	var r CuckooFilter
+	read, err := r.ReadFrom(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	require.Equal(t, written, read)
+	require.Equal(t, f.FingerprintBits(), r.FingerprintBits())
+	require.Equal(t, f.BucketSize(), r.BucketSize())
+	require.Equal(t, f.Capacity(), r.Capacity())
+	require.Equal(t, f.Count(), r.Count())
+	require.Equal(t, HashSchemeWyhash, r.Hasher().Scheme())
+	require.True(t, r.Test(n1))
+	require.False(t, r.Test(n2))
+	require.True(t, r.Remove(n1))
+	require.False(t, r.Test(n1))
+
+	ro, err := NewReadOnlyCuckooFilterFromBytes(buf.Bytes(), ParseOptions{VerifyChecksum: true})
+	require.NoError(t, err)
+	require.Equal(t, f.Count(), ro.Count())
+	require.True(t, ro.Test(n1))
+	require.False(t, ro.Test(n2))
+	require.NoError(t, ro.Verify())
+
+	buf.Bytes()[buf.Len()-1] ^= 0x80
+	require.True(t, errors.Is(ro.Verify(), ErrChecksumMismatch))
+	_, err = r.ReadFrom(bytes.NewReader(buf.Bytes()))
+	require.True(t, errors.Is(err, ErrChecksumMismatch), "unexpected error: %v", err)
+}
+
+func TestCuckooFilterHeaderErrors(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	_, err := NewCuckooFilter(100).WriteTo(buf)
+	require.NoError(t, err)
+	data := buf.Bytes()
+
+	corrupt := func(offset int, value byte) []byte {
+		c := append([]byte(nil), data...)
+		c[offset] = value
+		return c
+	}
+
+	// A header describing a huge table followed by a short payload.
+	forged := append([]byte(nil), data...)
+	binary.LittleEndian.PutUint64(forged[8:16], 1<<50)
+
+	tests := []struct {
+		name     string
+		data     []byte
+		expected error
+	}{
+		{name: "short header", data: data[:cuckooHeaderLen-1], expected: ErrTruncated},
+		{name: "forged buckets", data: forged, expected: ErrTruncated},
+		{name: "short payload", data: data[:len(data)-1], expected: ErrTruncated},
+		{name: "magic", data: corrupt(0, 'X'), expected: ErrInvalidMagic},
+		{name: "version", data: corrupt(4, 0xff), expected: ErrUnsupportedVersion},
+		{name: "hash scheme", data: corrupt(6, 0xff), expected: ErrUnsupportedHashScheme},
+		{name: "buckets", data: corrupt(8, 3), expected: ErrPayloadLength},
+		{name: "bucket size", data: corrupt(24, 0), expected: ErrInvalidBucketSize},
+		{name: "fingerprint bits", data: corrupt(26, 33), expected: ErrInvalidFingerprintBits},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewReadOnlyCuckooFilterFromBytes(tt.data, ParseOptions{})
+			require.True(t, errors.Is(err, tt.expected), "unexpected error: %v", err)
+
+			var r CuckooFilter
+			_, err = r.ReadFrom(bytes.NewReader(tt.data))
+			require.True(t, errors.Is(err, tt.expected), "unexpected error: %v", err)
+		})
+	}
+}
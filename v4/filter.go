@@ -0,0 +1,70 @@
+package bloom
+
+// Tester tests the membership of values in a set.
+type Tester interface {
+	// Test if value is in the set.
+	Test(value []byte) bool
+}
+
+// Filter is a set membership that values can be added to.
+type Filter interface {
+	Tester
+
+	// Add value to the set.
+	Add(value []byte)
+}
+
+// Sized is a set membership with a size of m and k hashes.
+type Sized interface {
+	// M returns the m elements represented.
+	M() uint
+
+	// K returns the k hashes used.
+	K() uint
+}
+
+var (
+	_ Filter = (*BloomFilter)(nil)
+	_ Filter = (*BlockedBloomFilter)(nil)
+	_ Filter = (*ConcurrentBloomFilter)(nil)
+	_ Filter = (*CountingBloomFilter)(nil)
+	_ Filter = (*SplitBlockBloomFilter)(nil)
+	_ Filter = (*PartitionedBloomFilter)(nil)
+	_ Filter = (*PersistentBloomFilter)(nil)
+	_ Filter = (*StableBloomFilter)(nil)
+
+	_ Tester = (*ReadOnlyBloomFilter)(nil)
+	_ Tester = (*ConcurrentReadOnlyBloomFilter)(nil)
+	_ Tester = (*MappedBloomFilter)(nil)
+	_ Tester = (*ScalableBloomFilter)(nil)
+	_ Tester = (*ConcurrentReadOnlyScalableBloomFilter)(nil)
+	_ Tester = (*ReadOnlyBlockedBloomFilter)(nil)
+	_ Tester = (*ConcurrentReadOnlyBlockedBloomFilter)(nil)
+	_ Tester = (*ReadOnlySplitBlockBloomFilter)(nil)
+	_ Tester = (*ReadOnlyPartitionedBloomFilter)(nil)
+	_ Tester = (*CuckooFilter)(nil)
+	_ Tester = (*QuotientFilter)(nil)
+	_ Tester = (*ReadOnlyCuckooFilter)(nil)
+	_ Tester = (*FuseFilter)(nil)
+	_ Tester = (*ConcurrentReadOnlyFuseFilter)(nil)
+	_ Tester = (*RibbonFilter)(nil)
+	_ Tester = (*ConcurrentReadOnlyRibbonFilter)(nil)
+	_ Tester = (*ConcurrentReadOnlyPartitionedBloomFilter)(nil)
+
+	_ Sized = (*BloomFilter)(nil)
+	_ Sized = (*BlockedBloomFilter)(nil)
+	_ Sized = (*ReadOnlyBlockedBloomFilter)(nil)
+	_ Sized = (*ConcurrentReadOnlyBlockedBloomFilter)(nil)
+	_ Sized = (*SplitBlockBloomFilter)(nil)
+	_ Sized = (*ReadOnlySplitBlockBloomFilter)(nil)
+	_ Sized = (*PartitionedBloomFilter)(nil)
+	_ Sized = (*ReadOnlyPartitionedBloomFilter)(nil)
+	_ Sized = (*ConcurrentReadOnlyPartitionedBloomFilter)(nil)
+	_ Sized = (*ConcurrentBloomFilter)(nil)
+	_ Sized = (*CountingBloomFilter)(nil)
+	_ Sized = (*StableBloomFilter)(nil)
+	_ Sized = (*PersistentBloomFilter)(nil)
+	_ Sized = (*ReadOnlyBloomFilter)(nil)
+	_ Sized = (*ConcurrentReadOnlyBloomFilter)(nil)
+	_ Sized = (*MappedBloomFilter)(nil)
+)
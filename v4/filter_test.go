@@ -0,0 +1,41 @@
+package bloom
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFilters(t *testing.T) {
+	n1 := []byte("Bess")
+	n2 := []byte("Jane")
+	for _, f := range []Filter{
+		NewBloomFilter(1000, 4),
+		NewConcurrentBloomFilter(1000, 4),
+	} {
+		f.Add(n1)
+		require.True(t, f.Test(n1))
+		require.False(t, f.Test(n2))
+
+		sized, ok := f.(Sized)
+		require.True(t, ok)
+		require.Equal(t, uint(1000), sized.M())
+		require.Equal(t, uint(4), sized.K())
+	}
+
+	f := NewBloomFilter(1000, 4)
+	f.Add(n1)
+	buf := bytes.NewBuffer(nil)
+	_, err := f.WriteTo(buf)
+	require.NoError(t, err)
+
+	ro, err := NewReadOnlyBloomFilterFromBytes(buf.Bytes(), ParseOptions{})
+	require.NoError(t, err)
+	cro, err := NewConcurrentReadOnlyBloomFilterFromBytes(buf.Bytes(), ParseOptions{})
+	require.NoError(t, err)
+	for _, tester := range []Tester{ro, cro} {
+		require.True(t, tester.Test(n1))
+		require.False(t, tester.Test(n2))
+	}
+}
@@ -0,0 +1,411 @@
+package bloom
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"math/bits"
+
+	"github.com/m3db/bitset"
+)
+
+const (
+	// headerMagic identifies a serialized bloom filter, it is "M3BF" when
+	// read as little endian bytes.
+	headerMagic uint32 = 0x4642334d
+	// formatVersion is the current version of the serialized format.
+	formatVersion uint16 = 1
+	// headerLen is the length in bytes of a serialized header.
+	headerLen = 40
+	// checksumOffset is the offset of the checksum in a serialized header,
+	// the header bytes before it are covered by the checksum.
+	checksumOffset = 32
+)
+
+var (
+	// ErrInvalidMagic is returned when serialized data does not start
+	// with the bloom filter magic number.
+	ErrInvalidMagic = errors.New("bloom: invalid magic number")
+	// ErrUnsupportedVersion is returned when serialized data was written
+	// with a format version this package cannot read.
+	ErrUnsupportedVersion = errors.New("bloom: unsupported format version")
+	// ErrUnsupportedHashScheme is returned when serialized data was written
+	// with a hash scheme this package cannot read.
+	ErrUnsupportedHashScheme = errors.New("bloom: unsupported hash scheme")
+	// ErrTruncated is returned when serialized data is shorter than its
+	// header describes.
+	ErrTruncated = errors.New("bloom: truncated data")
+	// ErrPayloadLength is returned when the payload length in a header does
+	// not match the length required by m.
+	ErrPayloadLength = errors.New("bloom: payload length does not match m")
+	// ErrChecksumMismatch is returned when the checksum of serialized data
+	// does not match the checksum stored with it.
+	ErrChecksumMismatch = errors.New("bloom: checksum mismatch")
+	// ErrNoChecksum is returned when verifying a filter that was not
+	// created from serialized data and so has no stored checksum.
+	ErrNoChecksum = errors.New("bloom: no checksum to verify")
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// HashScheme identifies the hash function used to build a filter.
+type HashScheme uint16
+
+const (
+	// HashSchemeMurmur3Entropy is murmur3 128 bit hashing of the value
+	// followed by hashing of the value with an appended entropy byte.
+	HashSchemeMurmur3Entropy HashScheme = 1
+)
+
+// Header describes a serialized bloom filter.
+type Header struct {
+	Version    uint16
+	HashScheme HashScheme
+	M          uint64
+	K          uint64
+	PayloadLen uint64
+	// Checksum is the CRC32C (Castagnoli) of the header fields
+	// above and the payload.
+	Checksum uint32
+}
+
+// ParseOptions are options for parsing a serialized bloom filter.
+type ParseOptions struct {
+	// VerifyChecksum verifies the checksum of the payload before returning
+	// the filter, which requires reading all of the payload.
+	VerifyChecksum bool
+	// Hasher is the hasher the filter must have been built with, if nil
+	// the filter may have been built with any of the built in hashers.
+	Hasher Hasher
+}
+
+// layout describes how the bits of a kind of filter are serialized, each
+// kind has its own magic number so that one cannot be read as another.
+type layout struct {
+	magic uint32
+	// payloadLen returns the length in bytes of the bits of a filter of m
+	// elements.
+	payloadLen func(m uint64) uint64
+	// maxM is the largest m of a filter, larger m would overflow its
+	// payload length, or zero if any m is valid.
+	maxM uint64
+}
+
+// bitSetLayout is the layout of BloomFilter and the read only filters.
+var bitSetLayout = layout{
+	magic:      headerMagic,
+	payloadLen: bitSetBytesLen,
+}
+
+// ParseHeader parses and validates the header at the start of data.
+func ParseHeader(data []byte) (Header, error) {
+	return bitSetLayout.parseHeader(data)
+}
+
+func (l layout) parseHeader(data []byte) (Header, error) {
+	if len(data) < headerLen {
+		return Header{}, ErrTruncated
+	}
+	if binary.LittleEndian.Uint32(data[0:4]) != l.magic {
+		return Header{}, ErrInvalidMagic
+	}
+	h := Header{
+		Version:    binary.LittleEndian.Uint16(data[4:6]),
+		HashScheme: HashScheme(binary.LittleEndian.Uint16(data[6:8])),
+		M:          binary.LittleEndian.Uint64(data[8:16]),
+		K:          binary.LittleEndian.Uint64(data[16:24]),
+		PayloadLen: binary.LittleEndian.Uint64(data[24:32]),
+		Checksum:   binary.LittleEndian.Uint32(data[32:36]),
+	}
+	if h.Version != formatVersion {
+		return Header{}, fmt.Errorf("%w: %d", ErrUnsupportedVersion, h.Version)
+	}
+	if err := validateParams(h.M, h.K); err != nil {
+		return Header{}, err
+	}
+	if l.maxM != 0 && h.M > l.maxM {
+		return Header{}, fmt.Errorf("%w: m=%d is larger than %d",
+			ErrPayloadLength, h.M, l.maxM)
+	}
+	if h.PayloadLen != l.payloadLen(h.M) {
+		return Header{}, fmt.Errorf("%w: m=%d, payload=%d",
+			ErrPayloadLength, h.M, h.PayloadLen)
+	}
+	return h, nil
+}
+
+func (h Header) encode(buf []byte, magic uint32) {
+	binary.LittleEndian.PutUint32(buf[0:4], magic)
+	binary.LittleEndian.PutUint16(buf[4:6], h.Version)
+	binary.LittleEndian.PutUint16(buf[6:8], uint16(h.HashScheme))
+	binary.LittleEndian.PutUint64(buf[8:16], h.M)
+	binary.LittleEndian.PutUint64(buf[16:24], h.K)
+	binary.LittleEndian.PutUint64(buf[24:32], h.PayloadLen)
+	binary.LittleEndian.PutUint32(buf[32:36], h.Checksum)
+	binary.LittleEndian.PutUint32(buf[36:40], 0)
+}
+
+// parsePayload parses the header in data and returns it along with the
+// payload it describes, the payload is not copied.
+func (l layout) parsePayload(
+	data []byte,
+	opts ParseOptions,
+) (Header, []byte, Hasher, *storedChecksum, error) {
+	h, err := l.parseHeader(data)
+	if err != nil {
+		return Header{}, nil, nil, nil, err
+	}
+	hasher, err := resolveHasher(h.HashScheme, opts.Hasher)
+	if err != nil {
+		return Header{}, nil, nil, nil, err
+	}
+	if uint64(len(data)-headerLen) < h.PayloadLen {
+		return Header{}, nil, nil, nil, ErrTruncated
+	}
+	sum := &storedChecksum{
+		header:  data[:checksumOffset],
+		payload: data[headerLen : headerLen+int(h.PayloadLen)],
+		value:   h.Checksum,
+	}
+	if opts.VerifyChecksum {
+		if err := sum.verify(); err != nil {
+			return Header{}, nil, nil, nil, err
+		}
+	}
+	return h, sum.payload, hasher, sum, nil
+}
+
+// storedChecksum is a checksum read from a header along with the
+// bytes it covers.
+type storedChecksum struct {
+	header  []byte
+	payload []byte
+	value   uint32
+}
+
+func (c *storedChecksum) verify() error {
+	if c == nil {
+		return ErrNoChecksum
+	}
+	if actual := checksum(c.header, c.payload); actual != c.value {
+		return fmt.Errorf("%w: expected %08x, got %08x",
+			ErrChecksumMismatch, c.value, actual)
+	}
+	return nil
+}
+
+func checksum(header, payload []byte) uint32 {
+	return crc32.Update(crc32.Checksum(header, castagnoli), castagnoli, payload)
+}
+
+// bitSetBytesLen returns the number of bytes a bitset.BitSet of m bits
+// occupies when written.
+func bitSetBytesLen(m uint64) uint64 {
+	return 8 * (m/64 + 1)
+}
+
+// WriteTo writes the bloom filter to a stream with a header describing
+// m, k and the hash scheme so that it can be read back without them.
+func (b *BloomFilter) WriteTo(w io.Writer) (int64, error) {
+	payload := bytes.NewBuffer(make([]byte, 0, bitSetBytesLen(b.m)))
+	if err := b.set.Write(payload); err != nil {
+		return 0, err
+	}
+	return bitSetLayout.write(w, b.m, b.k, b.hasher, payload.Bytes())
+}
+
+// write writes a header and the payload it describes to a stream.
+func (l layout) write(
+	w io.Writer,
+	m, k uint64,
+	hasher Hasher,
+	payload []byte,
+) (int64, error) {
+	var buf [headerLen]byte
+	h := Header{
+		Version:    formatVersion,
+		HashScheme: hasher.Scheme(),
+		M:          m,
+		K:          k,
+		PayloadLen: uint64(len(payload)),
+	}
+	h.encode(buf[:], l.magic)
+	h.Checksum = checksum(buf[:checksumOffset], payload)
+	h.encode(buf[:], l.magic)
+
+	cw := &countingWriter{w: w}
+	if _, err := cw.Write(buf[:]); err != nil {
+		return cw.n, err
+	}
+	_, err := cw.Write(payload)
+	return cw.n, err
+}
+
+// ReadFrom reads a bloom filter previously written with WriteTo from a
+// stream, replacing the contents of the bloom filter. If the bloom filter
+// has a hasher the stream must have been written with the same hasher.
+func (b *BloomFilter) ReadFrom(r io.Reader) (int64, error) {
+	h, payload, hasher, read, err := bitSetLayout.read(r, b.hasher)
+	if err != nil {
+		return read, err
+	}
+	b.m = h.M
+	b.k = h.K
+	b.set = bitSetFromBytes(h.M, payload)
+	b.hasher = hasher
+	return read, nil
+}
+
+// read reads a header and the payload it describes from a stream and
+// verifies the checksum of the payload, expected is the hasher the caller
+// requires the filter to use or nil for any of the built in hashers.
+func (l layout) read(
+	r io.Reader,
+	expected Hasher,
+) (Header, []byte, Hasher, int64, error) {
+	var buf [headerLen]byte
+	n, err := io.ReadFull(r, buf[:])
+	read := int64(n)
+	if err != nil {
+		return Header{}, nil, nil, read, readErr(err)
+	}
+	h, err := l.parseHeader(buf[:])
+	if err != nil {
+		return Header{}, nil, nil, read, err
+	}
+	hasher, err := resolveHasher(h.HashScheme, expected)
+	if err != nil {
+		return Header{}, nil, nil, read, err
+	}
+
+	payload, payloadRead, err := readPayload(r, h.PayloadLen)
+	read += payloadRead
+	if err != nil {
+		return Header{}, nil, nil, read, err
+	}
+	sum := &storedChecksum{
+		header:  buf[:checksumOffset],
+		payload: payload,
+		value:   h.Checksum,
+	}
+	if err := sum.verify(); err != nil {
+		return Header{}, nil, nil, read, err
+	}
+	return h, payload, hasher, read, nil
+}
+
+// NewReadOnlyBloomFilterFromBytes returns a new read only bloom filter
+// from data previously written with BloomFilter.WriteTo, the bits are
+// not copied so data can be a mmap'd bytes ref.
+// It is not concurrent read or write safe.
+func NewReadOnlyBloomFilterFromBytes(
+	data []byte,
+	opts ParseOptions,
+) (*ReadOnlyBloomFilter, error) {
+	h, payload, hasher, sum, err := bitSetLayout.parsePayload(data, opts)
+	if err != nil {
+		return nil, err
+	}
+	b, err := NewValidatedReadOnlyBloomFilterWithHasher(uint(h.M), uint(h.K), payload, hasher)
+	if err != nil {
+		return nil, err
+	}
+	b.checksum = sum
+	return b, nil
+}
+
+// NewConcurrentReadOnlyBloomFilterFromBytes returns a new concurrent read
+// only bloom filter from data previously written with BloomFilter.WriteTo,
+// the bits are not copied so data can be a mmap'd bytes ref.
+// It can be concurrently read from by any number of readers.
+func NewConcurrentReadOnlyBloomFilterFromBytes(
+	data []byte,
+	opts ParseOptions,
+) (*ConcurrentReadOnlyBloomFilter, error) {
+	h, payload, hasher, sum, err := bitSetLayout.parsePayload(data, opts)
+	if err != nil {
+		return nil, err
+	}
+	b, err := NewValidatedConcurrentReadOnlyBloomFilterWithHasher(uint(h.M), uint(h.K),
+		payload, hasher)
+	if err != nil {
+		return nil, err
+	}
+	b.checksum = sum
+	return b, nil
+}
+
+// Verify verifies the bits of the filter against the checksum stored when
+// it was serialized, it returns ErrNoChecksum if the filter was not created
+// from serialized data.
+func (b *ReadOnlyBloomFilter) Verify() error {
+	return b.checksum.verify()
+}
+
+// Verify verifies the bits of the filter against the checksum stored when
+// it was serialized, it returns ErrNoChecksum if the filter was not created
+// from serialized data. It is safe to call concurrently with Test.
+func (b *ConcurrentReadOnlyBloomFilter) Verify() error {
+	return b.checksum.verify()
+}
+
+func bitSetFromBytes(m uint64, data []byte) *bitset.BitSet {
+	set := bitset.NewBitSet(uint(m))
+	for i := 0; i+8 <= len(data); i += 8 {
+		word := binary.LittleEndian.Uint64(data[i : i+8])
+		for word != 0 {
+			set.Set(uint(i*8 + bits.TrailingZeros64(word)))
+			word &= word - 1
+		}
+	}
+	return set
+}
+
+// initialPayloadCap bounds the buffer allocated up front when reading a
+// payload from a stream.
+const initialPayloadCap = 1 << 20
+
+// readPayload reads a payload of n bytes from a stream. n comes from a
+// header that has not been verified yet so the buffer grows as the payload
+// arrives rather than being allocated up front, a corrupted length returns
+// ErrTruncated when the stream ends instead of allocating n bytes.
+func readPayload(r io.Reader, n uint64) ([]byte, int64, error) {
+	if n > math.MaxInt64 {
+		return nil, 0, fmt.Errorf("%w: %d bytes", ErrPayloadLength, n)
+	}
+	initial := n
+	if initial > initialPayloadCap {
+		initial = initialPayloadCap
+	}
+	buf := bytes.NewBuffer(make([]byte, 0, initial))
+	read, err := buf.ReadFrom(io.LimitReader(r, int64(n)))
+	if err != nil {
+		return nil, read, readErr(err)
+	}
+	if uint64(read) < n {
+		return nil, read, ErrTruncated
+	}
+	return buf.Bytes(), read, nil
+}
+
+func readErr(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return ErrTruncated
+	}
+	return err
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.n += int64(n)
+	return n, err
+}
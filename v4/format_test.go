@@ -0,0 +1,185 @@
+package bloom
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteToReadFrom(t *testing.T) {
+	m, k := EstimateFalsePositiveRate(1000, 0.01)
+	f := NewBloomFilter(m, k)
+	n1 := []byte("Bess")
+	n2 := []byte("Jane")
+	n3 := []byte("Emma")
+	f.Add(n1)
+	f.Add(n3)
+
+	buf := bytes.NewBuffer(nil)
+	written, err := f.WriteTo(buf)
+	require.NoError(t, err)
+	require.Equal(t, int64(buf.Len()), written)
+	require.Equal(t, "M3BF", string(buf.Bytes()[:4]))
+
+	var r BloomFilter
+	read, err := r.ReadFrom(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	require.Equal(t, written, read)
+	require.Equal(t, f.M(), r.M())
+	require.Equal(t, f.K(), r.K())
+	require.True(t, r.Test(n1))
+	require.False(t, r.Test(n2))
+	require.True(t, r.Test(n3))
+
+	var expected, actual bytes.Buffer
+	require.NoError(t, f.BitSet().Write(&expected))
+	require.NoError(t, r.BitSet().Write(&actual))
+	require.Equal(t, expected.Bytes(), actual.Bytes())
+}
+
+func TestReadOnlyFromBytes(t *testing.T) {
+	f := NewBloomFilter(1000, 4)
+	n1 := []byte("Bess")
+	n2 := []byte("Jane")
+	f.Add(n1)
+
+	buf := bytes.NewBuffer(nil)
+	_, err := f.WriteTo(buf)
+	require.NoError(t, err)
+
+	ro, err := NewReadOnlyBloomFilterFromBytes(buf.Bytes(), ParseOptions{})
+	require.NoError(t, err)
+	require.Equal(t, f.M(), ro.M())
+	require.Equal(t, f.K(), ro.K())
+	require.True(t, ro.Test(n1))
+	require.False(t, ro.Test(n2))
+
+	cro, err := NewConcurrentReadOnlyBloomFilterFromBytes(buf.Bytes(), ParseOptions{})
+	require.NoError(t, err)
+	require.Equal(t, f.M(), cro.M())
+	require.Equal(t, f.K(), cro.K())
+	require.True(t, cro.Test(n1))
+	require.False(t, cro.Test(n2))
+}
+
+func TestParseHeaderErrors(t *testing.T) {
+	f := NewBloomFilter(1000, 4)
+	buf := bytes.NewBuffer(nil)
+	_, err := f.WriteTo(buf)
+	require.NoError(t, err)
+	data := buf.Bytes()
+
+	corrupt := func(offset int, value byte) []byte {
+		c := append([]byte(nil), data...)
+		c[offset] = value
+		return c
+	}
+
+	tests := []struct {
+		name     string
+		data     []byte
+		expected error
+	}{
+		{name: "short header", data: data[:headerLen-1], expected: ErrTruncated},
+		{name: "short payload", data: data[:len(data)-1], expected: ErrTruncated},
+		{name: "magic", data: corrupt(0, 'X'), expected: ErrInvalidMagic},
+		{name: "version", data: corrupt(4, 0xff), expected: ErrUnsupportedVersion},
+		{name: "hash scheme", data: corrupt(6, 0xff), expected: ErrUnsupportedHashScheme},
+		{name: "m", data: corrupt(9, 0xff), expected: ErrPayloadLength},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewReadOnlyBloomFilterFromBytes(tt.data, ParseOptions{})
+			require.True(t, errors.Is(err, tt.expected), "unexpected error: %v", err)
+
+			var r BloomFilter
+			_, err = r.ReadFrom(bytes.NewReader(tt.data))
+			require.True(t, errors.Is(err, tt.expected), "unexpected error: %v", err)
+		})
+	}
+}
+
+func TestReadFromForgedPayloadLen(t *testing.T) {
+	// A header describing a huge filter, with a payload length matching m,
+	// followed by only a few bytes of payload.
+	forge := func(l layout, m, k uint64) []byte {
+		var buf [headerLen]byte
+		Header{
+			Version:    formatVersion,
+			HashScheme: Murmur3Hasher.Scheme(),
+			M:          m,
+			K:          k,
+			PayloadLen: l.payloadLen(m),
+		}.encode(buf[:], l.magic)
+		return append(buf[:], make([]byte, 64)...)
+	}
+
+	var b BloomFilter
+	_, err := b.ReadFrom(bytes.NewReader(forge(bitSetLayout, 1<<62, 4)))
+	require.True(t, errors.Is(err, ErrTruncated), "unexpected error: %v", err)
+
+	var bb BlockedBloomFilter
+	_, err = bb.ReadFrom(bytes.NewReader(forge(blockedLayout, 1<<62, 4)))
+	require.True(t, errors.Is(err, ErrTruncated), "unexpected error: %v", err)
+
+	var pb PartitionedBloomFilter
+	_, err = pb.ReadFrom(bytes.NewReader(forge(partitionedLayout, 1<<62, 4)))
+	require.True(t, errors.Is(err, ErrTruncated), "unexpected error: %v", err)
+}
+
+func TestChecksum(t *testing.T) {
+	f := NewBloomFilter(1000, 4)
+	f.Add([]byte("Bess"))
+
+	buf := bytes.NewBuffer(nil)
+	_, err := f.WriteTo(buf)
+	require.NoError(t, err)
+	data := buf.Bytes()
+
+	opts := ParseOptions{VerifyChecksum: true}
+	ro, err := NewReadOnlyBloomFilterFromBytes(data, opts)
+	require.NoError(t, err)
+	require.NoError(t, ro.Verify())
+
+	cro, err := NewConcurrentReadOnlyBloomFilterFromBytes(data, opts)
+	require.NoError(t, err)
+	require.NoError(t, cro.Verify())
+
+	// Flip a bit in the payload after the filters have been created.
+	data[len(data)-1] ^= 0x80
+	require.True(t, errors.Is(ro.Verify(), ErrChecksumMismatch))
+	require.True(t, errors.Is(cro.Verify(), ErrChecksumMismatch))
+
+	_, err = NewReadOnlyBloomFilterFromBytes(data, opts)
+	require.True(t, errors.Is(err, ErrChecksumMismatch), "unexpected error: %v", err)
+	_, err = NewConcurrentReadOnlyBloomFilterFromBytes(data, opts)
+	require.True(t, errors.Is(err, ErrChecksumMismatch), "unexpected error: %v", err)
+
+	var r BloomFilter
+	_, err = r.ReadFrom(bytes.NewReader(data))
+	require.True(t, errors.Is(err, ErrChecksumMismatch), "unexpected error: %v", err)
+
+	// Verification is optional when parsing.
+	_, err = NewReadOnlyBloomFilterFromBytes(data, ParseOptions{})
+	require.NoError(t, err)
+
+	// A changed k is also covered by the checksum.
+	data[len(data)-1] ^= 0x80
+	data[16]++
+	_, err = NewReadOnlyBloomFilterFromBytes(data, opts)
+	require.True(t, errors.Is(err, ErrChecksumMismatch), "unexpected error: %v", err)
+}
+
+func TestVerifyNoChecksum(t *testing.T) {
+	f := NewBloomFilter(1000, 4)
+	buf := bytes.NewBuffer(nil)
+	require.NoError(t, f.BitSet().Write(buf))
+
+	ro := NewReadOnlyBloomFilter(f.M(), f.K(), buf.Bytes())
+	require.Equal(t, ErrNoChecksum, ro.Verify())
+
+	cro := NewConcurrentReadOnlyBloomFilter(f.M(), f.K(), buf.Bytes())
+	require.Equal(t, ErrNoChecksum, cro.Verify())
+}
@@ -0,0 +1,418 @@
+package bloom
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/bits"
+	"sort"
+)
+
+const (
+	// fuseHeaderMagic identifies a serialized binary fuse filter, it is
+	// "M3FF" when read as little endian bytes.
+	fuseHeaderMagic uint32 = 0x4646334d
+	// fuseHeaderLen is the length in bytes of a serialized binary fuse
+	// filter header.
+	fuseHeaderLen = 40
+	// fuseChecksumOffset is the offset of the checksum in a serialized
+	// binary fuse filter header, the bytes before it and the fingerprints
+	// are covered by it.
+	fuseChecksumOffset = 32
+
+	// fuseArity is the number of fingerprints each key maps to.
+	fuseArity = 3
+	// maxFuseSegmentLength bounds the length of a segment so that the
+	// segments of a key stay close together in memory.
+	maxFuseSegmentLength = 1 << 18
+	// maxFuseAttempts is the number of seeds tried before construction
+	// gives up, each attempt succeeds with high probability.
+	maxFuseAttempts = 100
+)
+
+// ErrFuseConstruction is returned when a binary fuse filter could not be
+// built from a set of keys with any of the seeds tried.
+var ErrFuseConstruction = errors.New("bloom: could not build fuse filter")
+
+// DuplicateKeysError is returned when building a binary fuse filter from
+// keys that are not distinct.
+type DuplicateKeysError struct {
+	// Keys are the keys that occur more than once, each listed once.
+	Keys [][]byte
+}
+
+func (e *DuplicateKeysError) Error() string {
+	return fmt.Sprintf("bloom: %d duplicate keys", len(e.Keys))
+}
+
+// fuseTable is the fingerprints of a binary fuse filter and the parameters
+// that map a key to three of them.
+type fuseTable struct {
+	seed          uint64
+	count         uint64
+	segmentLength uint32
+	segmentCount  uint32
+	fingerprints  []byte
+	hasher        Hasher
+}
+
+func newFuseTable(n uint64, hasher Hasher) fuseTable {
+	segmentLength := uint32(4)
+	sizeFactor := 1.125
+	if n > 1 {
+		// The parameters of 3-wise binary fuse filters given by Graf and
+		// Lemire, which succeed with high probability.
+		segmentLength = 1 << uint(math.Floor(math.Log(float64(n))/math.Log(3.33)+2.25))
+		sizeFactor = math.Max(1.125, 0.875+0.25*math.Log(1000000)/math.Log(float64(n)))
+	}
+	if segmentLength > maxFuseSegmentLength {
+		segmentLength = maxFuseSegmentLength
+	}
+	capacity := uint64(math.Round(float64(n) * sizeFactor))
+	segmentCount := uint32(1)
+	if segments := (capacity + uint64(segmentLength) - 1) / uint64(segmentLength); segments > fuseArity-1 {
+		segmentCount = uint32(segments - (fuseArity - 1))
+	}
+	return fuseTable{
+		count:         n,
+		segmentLength: segmentLength,
+		segmentCount:  segmentCount,
+		fingerprints:  make([]byte, fuseArrayLen(segmentLength, segmentCount)),
+		hasher:        hasher,
+	}
+}
+
+// fuseArrayLen returns the number of fingerprints of a binary fuse filter,
+// the last arity-1 segments are only the second or third segment of keys.
+func fuseArrayLen(segmentLength, segmentCount uint32) uint64 {
+	return (uint64(segmentCount) + fuseArity - 1) * uint64(segmentLength)
+}
+
+// mix returns the hash of a key for the seed of the table.
+func (t *fuseTable) mix(h Digest) uint64 {
+	return splitmix64(h[0] + t.seed)
+}
+
+// locations returns the fingerprints a hash maps to, one in each of three
+// consecutive segments.
+func (t *fuseTable) locations(hash uint64) (uint64, uint64, uint64) {
+	hi, _ := bits.Mul64(hash, uint64(t.segmentCount)*uint64(t.segmentLength))
+	mask := uint64(t.segmentLength - 1)
+	h0 := hi
+	h1 := (h0 + uint64(t.segmentLength)) ^ (hash>>18)&mask
+	h2 := (h0 + 2*uint64(t.segmentLength)) ^ hash&mask
+	return h0, h1, h2
+}
+
+func fuseFingerprint(hash uint64) byte {
+	return byte(hash ^ hash>>32)
+}
+
+func (t *fuseTable) test(h Digest) bool {
+	hash := t.mix(h)
+	h0, h1, h2 := t.locations(hash)
+	return fuseFingerprint(hash) ==
+		t.fingerprints[h0]^t.fingerprints[h1]^t.fingerprints[h2]
+}
+
+// populate assigns the fingerprints of the hashes of distinct keys with
+// the seed of the table, returning false if the keys could not be peeled.
+func (t *fuseTable) populate(digests []Digest) bool {
+	var (
+		size   = len(t.fingerprints)
+		counts = make([]uint32, size)
+		xors   = make([]uint64, size)
+		queue  = make([]uint64, 0, size)
+		stack  = make([]uint64, 0, len(digests))
+		owner  = make([]uint64, 0, len(digests))
+	)
+	for _, d := range digests {
+		hash := t.mix(d)
+		h0, h1, h2 := t.locations(hash)
+		for _, i := range [fuseArity]uint64{h0, h1, h2} {
+			counts[i]++
+			xors[i] ^= hash
+		}
+	}
+	for i, c := range counts {
+		if c == 1 {
+			queue = append(queue, uint64(i))
+		}
+	}
+	// Repeatedly remove a key that is the only key mapping to one of its
+	// fingerprints, the fingerprints are then assigned in reverse order so
+	// that each key's own fingerprint is set last.
+	for len(queue) > 0 {
+		i := queue[len(queue)-1]
+		queue = queue[:len(queue)-1]
+		if counts[i] != 1 {
+			continue
+		}
+		hash := xors[i]
+		stack = append(stack, hash)
+		owner = append(owner, i)
+		h0, h1, h2 := t.locations(hash)
+		for _, j := range [fuseArity]uint64{h0, h1, h2} {
+			counts[j]--
+			xors[j] ^= hash
+			if counts[j] == 1 {
+				queue = append(queue, j)
+			}
+		}
+	}
+	if len(stack) != len(digests) {
+		return false
+	}
+	for i := len(stack) - 1; i >= 0; i-- {
+		hash := stack[i]
+		h0, h1, h2 := t.locations(hash)
+		t.fingerprints[owner[i]] = 0
+		t.fingerprints[owner[i]] = fuseFingerprint(hash) ^
+			t.fingerprints[h0] ^ t.fingerprints[h1] ^ t.fingerprints[h2]
+	}
+	return true
+}
+
+// FuseFilter is an immutable binary fuse filter set membership built from
+// a complete set of keys, as described by Graf and Lemire in "Binary Fuse
+// Filters: Fast and Smaller Than Xor Filters". Each key maps to three 8 bit
+// fingerprints that XOR to the fingerprint of the key, giving a false
+// positive rate of about 1/256 with 9 to 9.5 bits per key for sets of 100k
+// keys or more, about 20% fewer than BloomFilter at the same false
+// positive rate. Keys cannot be added after it is built.
+// It can be concurrently read from by any number of readers.
+type FuseFilter struct {
+	fuseTable
+}
+
+// BuildFuseFilter builds a binary fuse filter from a set of distinct keys,
+// a DuplicateKeysError is returned if any key occurs more than once.
+func BuildFuseFilter(keys [][]byte) (*FuseFilter, error) {
+	return BuildFuseFilterWithHasher(keys, Murmur3Hasher)
+}
+
+// BuildFuseFilterWithHasher builds a binary fuse filter from a set of
+// distinct keys using a hasher, or Murmur3Hasher if nil. A
+// DuplicateKeysError is returned if any key occurs more than once.
+func BuildFuseFilterWithHasher(keys [][]byte, hasher Hasher) (*FuseFilter, error) {
+	hasher = hasherOrDefault(hasher)
+	digests, err := fuseDigests(keys, hasher)
+	if err != nil {
+		return nil, err
+	}
+	t := newFuseTable(uint64(len(digests)), hasher)
+	rng := uint64(0)
+	for attempt := 0; attempt < maxFuseAttempts; attempt++ {
+		rng += 0x9e3779b97f4a7c15
+		t.seed = splitmix64(rng)
+		if t.populate(digests) {
+			return &FuseFilter{fuseTable: t}, nil
+		}
+		for i := range t.fingerprints {
+			t.fingerprints[i] = 0
+		}
+	}
+	return nil, fmt.Errorf("%w: %d keys after %d attempts",
+		ErrFuseConstruction, len(keys), maxFuseAttempts)
+}
+
+// fuseDigests returns the digests of the keys with one digest for keys
+// whose hashes collide, which the filter cannot tell apart. Keys that are
+// equal are returned in a DuplicateKeysError.
+func fuseDigests(keys [][]byte, hasher Hasher) ([]Digest, error) {
+	order := make([]int, len(keys))
+	digests := make([]Digest, len(keys))
+	for i, key := range keys {
+		order[i] = i
+		digests[i] = Digest(hasher.Sum(key))
+	}
+	sort.Slice(order, func(i, j int) bool {
+		return digests[order[i]][0] < digests[order[j]][0]
+	})
+
+	var (
+		unique     = make([]Digest, 0, len(keys))
+		duplicates [][]byte
+	)
+	for i := 0; i < len(order); {
+		j := i + 1
+		for j < len(order) && digests[order[j]][0] == digests[order[i]][0] {
+			j++
+		}
+		unique = append(unique, digests[order[i]])
+		duplicates = appendDuplicates(duplicates, keys, order[i:j])
+		i = j
+	}
+	if len(duplicates) > 0 {
+		return nil, &DuplicateKeysError{Keys: duplicates}
+	}
+	return unique, nil
+}
+
+// appendDuplicates appends the keys that occur more than once among keys
+// with the same hash.
+func appendDuplicates(duplicates, keys [][]byte, same []int) [][]byte {
+	for a := range same {
+		seen, repeated := false, false
+		for b := range same {
+			if bytes.Equal(keys[same[a]], keys[same[b]]) {
+				seen = seen || b < a
+				repeated = repeated || b != a
+			}
+		}
+		if repeated && !seen {
+			duplicates = append(duplicates, keys[same[a]])
+		}
+	}
+	return duplicates
+}
+
+// Test if value is in the set.
+func (b *FuseFilter) Test(value []byte) bool {
+	return b.test(Digest(b.hasher.Sum(value)))
+}
+
+// TestDigest tests if the value with a digest is in the set, the digest
+// must have been computed with the hasher of the filter.
+func (b *FuseFilter) TestDigest(h Digest) bool {
+	return b.test(h)
+}
+
+// Count returns the number of distinct key hashes the filter was built
+// from.
+func (b *FuseFilter) Count() uint64 {
+	return b.count
+}
+
+// Hasher returns the hasher used.
+func (b *FuseFilter) Hasher() Hasher {
+	return b.hasher
+}
+
+// WriteTo writes the binary fuse filter to a stream with a header
+// describing its segments, seed and the hash scheme.
+func (b *FuseFilter) WriteTo(w io.Writer) (int64, error) {
+	var buf [fuseHeaderLen]byte
+	b.encodeHeader(buf[:])
+	cw := &countingWriter{w: w}
+	if _, err := cw.Write(buf[:]); err != nil {
+		return cw.n, err
+	}
+	_, err := cw.Write(b.fingerprints)
+	return cw.n, err
+}
+
+// ConcurrentReadOnlyFuseFilter is a concurrent read only binary fuse
+// filter set membership backed by a byte slice, this means it can be used
+// with a mmap'd bytes ref. It can be concurrently read from by any number
+// of readers.
+type ConcurrentReadOnlyFuseFilter struct {
+	fuseTable
+	checksum *storedChecksum
+}
+
+// NewConcurrentReadOnlyFuseFilterFromBytes returns a new concurrent read
+// only binary fuse filter from data previously written with
+// FuseFilter.WriteTo, the fingerprints are not copied so data can be a
+// mmap'd bytes ref. It can be concurrently read from by any number of
+// readers.
+func NewConcurrentReadOnlyFuseFilterFromBytes(
+	data []byte,
+	opts ParseOptions,
+) (*ConcurrentReadOnlyFuseFilter, error) {
+	if len(data) < fuseHeaderLen {
+		return nil, ErrTruncated
+	}
+	t, err := parseFuseHeader(data, opts.Hasher)
+	if err != nil {
+		return nil, err
+	}
+	size := fuseArrayLen(t.segmentLength, t.segmentCount)
+	if uint64(len(data)-fuseHeaderLen) < size {
+		return nil, ErrTruncated
+	}
+	t.fingerprints = data[fuseHeaderLen : fuseHeaderLen+int(size)]
+	sum := &storedChecksum{
+		header:  data[:fuseChecksumOffset],
+		payload: t.fingerprints,
+		value:   binary.LittleEndian.Uint32(data[32:36]),
+	}
+	if opts.VerifyChecksum {
+		if err := sum.verify(); err != nil {
+			return nil, err
+		}
+	}
+	return &ConcurrentReadOnlyFuseFilter{fuseTable: t, checksum: sum}, nil
+}
+
+// Test if value is in the set.
+func (b *ConcurrentReadOnlyFuseFilter) Test(value []byte) bool {
+	return b.test(Digest(b.hasher.Sum(value)))
+}
+
+// TestDigest tests if the value with a digest is in the set, the digest
+// must have been computed with the hasher of the filter.
+func (b *ConcurrentReadOnlyFuseFilter) TestDigest(h Digest) bool {
+	return b.test(h)
+}
+
+// Count returns the number of distinct key hashes the filter was built
+// from.
+func (b *ConcurrentReadOnlyFuseFilter) Count() uint64 {
+	return b.count
+}
+
+// Hasher returns the hasher used.
+func (b *ConcurrentReadOnlyFuseFilter) Hasher() Hasher {
+	return b.hasher
+}
+
+// Verify verifies the fingerprints of the filter against the checksum
+// stored when it was serialized.
+func (b *ConcurrentReadOnlyFuseFilter) Verify() error {
+	return b.checksum.verify()
+}
+
+func (t *fuseTable) encodeHeader(buf []byte) {
+	binary.LittleEndian.PutUint32(buf[0:4], fuseHeaderMagic)
+	binary.LittleEndian.PutUint16(buf[4:6], formatVersion)
+	binary.LittleEndian.PutUint16(buf[6:8], uint16(t.hasher.Scheme()))
+	binary.LittleEndian.PutUint64(buf[8:16], t.seed)
+	binary.LittleEndian.PutUint64(buf[16:24], t.count)
+	binary.LittleEndian.PutUint32(buf[24:28], t.segmentLength)
+	binary.LittleEndian.PutUint32(buf[28:32], t.segmentCount)
+	binary.LittleEndian.PutUint32(buf[32:36],
+		checksum(buf[:fuseChecksumOffset], t.fingerprints))
+	binary.LittleEndian.PutUint32(buf[36:40], 0)
+}
+
+// parseFuseHeader parses the header of a binary fuse filter, returning
+// its table without fingerprints.
+func parseFuseHeader(data []byte, expected Hasher) (fuseTable, error) {
+	if binary.LittleEndian.Uint32(data[0:4]) != fuseHeaderMagic {
+		return fuseTable{}, ErrInvalidMagic
+	}
+	if v := binary.LittleEndian.Uint16(data[4:6]); v != formatVersion {
+		return fuseTable{}, fmt.Errorf("%w: %d", ErrUnsupportedVersion, v)
+	}
+	hasher, err := resolveHasher(HashScheme(binary.LittleEndian.Uint16(data[6:8])), expected)
+	if err != nil {
+		return fuseTable{}, err
+	}
+	t := fuseTable{
+		seed:          binary.LittleEndian.Uint64(data[8:16]),
+		count:         binary.LittleEndian.Uint64(data[16:24]),
+		segmentLength: binary.LittleEndian.Uint32(data[24:28]),
+		segmentCount:  binary.LittleEndian.Uint32(data[28:32]),
+		hasher:        hasher,
+	}
+	if t.segmentLength == 0 || t.segmentLength&(t.segmentLength-1) != 0 ||
+		t.segmentLength > maxFuseSegmentLength || t.segmentCount == 0 {
+		return fuseTable{}, fmt.Errorf("%w: %d segments of %d",
+			ErrPayloadLength, t.segmentCount, t.segmentLength)
+	}
+	return t, nil
+}
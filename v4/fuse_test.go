@@ -0,0 +1,158 @@
+package bloom
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTestFuseKeys(n int) [][]byte {
+	keys := make([][]byte, n)
+	for i := range keys {
+		keys[i] = []byte(fmt.Sprintf("key-%d", i))
+	}
+	return keys
+}
+
+func TestFuseFilter(t *testing.T) {
+	for _, n := range []int{0, 1, 2, 10, 1000, 100000} {
+		t.Run(fmt.Sprintf("%d keys", n), func(t *testing.T) {
+			keys := newTestFuseKeys(n)
+			f, err := BuildFuseFilter(keys)
+			require.NoError(t, err)
+			require.Equal(t, uint64(n), f.Count())
+			for _, key := range keys {
+				require.True(t, f.Test(key))
+			}
+		})
+	}
+}
+
+func TestFuseFilterFalsePositiveRate(t *testing.T) {
+	const n = 100000
+	f, err := BuildFuseFilter(newTestFuseKeys(n))
+	require.NoError(t, err)
+
+	var fp int
+	for i := 0; i < n; i++ {
+		if f.Test([]byte(fmt.Sprintf("other-%d", i))) {
+			fp++
+		}
+	}
+	require.InDelta(t, 1.0/256, float64(fp)/n, 0.001)
+
+	// Fewer bits per key than a bloom filter with the same false positive
+	// rate.
+	plan, err := PlanForFalsePositiveRate(n, 1.0/256)
+	require.NoError(t, err)
+	bitsPerKey := float64(8*len(f.fingerprints)) / n
+	require.True(t, bitsPerKey < 0.85*float64(plan.M)/n,
+		"fuse: %f bits per key, bloom: %f", bitsPerKey, float64(plan.M)/n)
+}
+
+func TestFuseFilterDuplicateKeys(t *testing.T) {
+	keys := newTestFuseKeys(100)
+	keys = append(keys, []byte("key-3"), []byte("key-7"), []byte("key-3"))
+	_, err := BuildFuseFilter(keys)
+	var dupErr *DuplicateKeysError
+	require.True(t, errors.As(err, &dupErr), "unexpected error: %v", err)
+	require.ElementsMatch(t, [][]byte{[]byte("key-3"), []byte("key-7")}, dupErr.Keys)
+}
+
+// collidingHasher hashes every value to the same digest.
+type collidingHasher struct{}
+
+func (collidingHasher) Sum([]byte) [4]uint64 { return [4]uint64{1, 2, 3, 4} }
+func (collidingHasher) Scheme() HashScheme   { return 0xffff }
+
+func TestFuseFilterHashCollisions(t *testing.T) {
+	// Distinct keys with the same hash cannot be told apart, they are
+	// stored once rather than reported as duplicates.
+	f, err := BuildFuseFilterWithHasher(newTestFuseKeys(3), collidingHasher{})
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), f.Count())
+	require.True(t, f.Test([]byte("key-0")))
+}
+
+func TestFuseFilterWriteTo(t *testing.T) {
+	keys := newTestFuseKeys(1000)
+	f, err := BuildFuseFilterWithHasher(keys, XXHash64Hasher)
+	require.NoError(t, err)
+
+	buf := bytes.NewBuffer(nil)
+	written, err := f.WriteTo(buf)
+	require.NoError(t, err)
+	require.Equal(t, int64(buf.Len()), written)
+
+	ro, err := NewConcurrentReadOnlyFuseFilterFromBytes(buf.Bytes(),
+		ParseOptions{VerifyChecksum: true})
+	require.NoError(t, err)
+	require.Equal(t, f.Count(), ro.Count())
+	require.Equal(t, HashSchemeXXHash64, ro.Hasher().Scheme())
+	require.NoError(t, ro.Verify())
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for _, key := range keys {
+				require.True(t, ro.Test(key))
+			}
+		}()
+	}
+	wg.Wait()
+
+	buf.Bytes()[buf.Len()-1] ^= 0x80
+	require.True(t, errors.Is(ro.Verify(), ErrChecksumMismatch))
+}
+
+func TestFuseFilterHeaderErrors(t *testing.T) {
+	f, err := BuildFuseFilter(newTestFuseKeys(100))
+	require.NoError(t, err)
+	buf := bytes.NewBuffer(nil)
+	_, err = f.WriteTo(buf)
+	require.NoError(t, err)
+	data := buf.Bytes()
+
+	corrupt := func(offset int, value byte) []byte {
+		c := append([]byte(nil), data...)
+		c[offset] = value
+		return c
+	}
+
+	tests := []struct {
+		name     string
+		data     []byte
+		expected error
+	}{
+		{name: "short header", data: data[:fuseHeaderLen-1], expected: ErrTruncated},
+		{name: "short payload", data: data[:len(data)-1], expected: ErrTruncated},
+		{name: "magic", data: corrupt(0, 'X'), expected: ErrInvalidMagic},
+		{name: "version", data: corrupt(4, 0xff), expected: ErrUnsupportedVersion},
+		{name: "hash scheme", data: corrupt(6, 0xff), expected: ErrUnsupportedHashScheme},
+		{name: "segment length", data: corrupt(24, 3), expected: ErrPayloadLength},
+		{name: "checksum", data: corrupt(8, data[8]+1), expected: ErrChecksumMismatch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewConcurrentReadOnlyFuseFilterFromBytes(tt.data,
+				ParseOptions{VerifyChecksum: true})
+			require.True(t, errors.Is(err, tt.expected), "unexpected error: %v", err)
+		})
+	}
+}
+
+func BenchmarkBuildFuseFilter100k(b *testing.B) {
+	keys := newTestFuseKeys(100 * 1000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := BuildFuseFilter(keys); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
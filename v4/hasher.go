@@ -0,0 +1,142 @@
+package bloom
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrHashSchemeMismatch is returned when a serialized filter was built with
+// a different hasher than the one it is being read with.
+var ErrHashSchemeMismatch = errors.New("bloom: hash scheme mismatch")
+
+const (
+	// HashSchemeXXHash64 is the 64 bit xxHash of the value, extended to
+	// four words by mixing.
+	HashSchemeXXHash64 HashScheme = 2
+	// HashSchemeWyhash is the 64 bit wyhash of the value, extended to
+	// four words by mixing.
+	HashSchemeWyhash HashScheme = 3
+)
+
+// Hasher hashes values into the four 64 bit words that are combined
+// to locate the k bits of a value in a filter.
+type Hasher interface {
+	// Sum returns the four 64 bit words for a value.
+	Sum(value []byte) [4]uint64
+	// Scheme returns the identifier recorded for the hasher
+	// in serialized filters.
+	Scheme() HashScheme
+}
+
+var (
+	// Murmur3Hasher hashes values with murmur3, it is the hasher used
+	// when none is specified.
+	Murmur3Hasher Hasher = murmur3Hasher{}
+	// XXHash64Hasher hashes values with xxHash64, it has 64 rather than
+	// 256 bits of entropy.
+	XXHash64Hasher Hasher = xxhash64Hasher{}
+	// WyhashHasher hashes values with wyhash, it is faster than
+	// Murmur3Hasher but has 64 rather than 256 bits of entropy.
+	WyhashHasher Hasher = wyhashHasher{}
+)
+
+// Digest is the hash of a value, it can be computed once with Hash and
+// used to add the value to or test the value against many filters that
+// use the same hasher.
+type Digest [4]uint64
+
+// Hash returns the digest of a value for filters using Murmur3Hasher,
+// which is the hasher used when none is specified.
+func Hash(value []byte) Digest {
+	return Digest(sum128WithEntropy(value))
+}
+
+// HashWith returns the digest of a value for filters using a hasher, or
+// Murmur3Hasher if nil.
+func HashWith(hasher Hasher, value []byte) Digest {
+	hasher = hasherOrDefault(hasher)
+	return Digest(hasher.Sum(value))
+}
+
+// HasherForScheme returns the built in hasher for a hash scheme.
+func HasherForScheme(scheme HashScheme) (Hasher, error) {
+	switch scheme {
+	case HashSchemeMurmur3Entropy:
+		return Murmur3Hasher, nil
+	case HashSchemeXXHash64:
+		return XXHash64Hasher, nil
+	case HashSchemeWyhash:
+		return WyhashHasher, nil
+	}
+	return nil, fmt.Errorf("%w: %d", ErrUnsupportedHashScheme, scheme)
+}
+
+// hasherOrDefault returns hasher, or Murmur3Hasher if hasher is nil.
+func hasherOrDefault(hasher Hasher) Hasher {
+	if hasher == nil {
+		return Murmur3Hasher
+	}
+	return hasher
+}
+
+// resolveHasher returns the hasher for a serialized hash scheme, expected
+// is the hasher the caller requires the filter to use or nil for any of
+// the built in hashers.
+func resolveHasher(scheme HashScheme, expected Hasher) (Hasher, error) {
+	if expected == nil {
+		return HasherForScheme(scheme)
+	}
+	if expected.Scheme() != scheme {
+		return nil, fmt.Errorf("%w: expected %d, got %d",
+			ErrHashSchemeMismatch, expected.Scheme(), scheme)
+	}
+	return expected, nil
+}
+
+type murmur3Hasher struct{}
+
+func (murmur3Hasher) Sum(value []byte) [4]uint64 {
+	return sum128WithEntropy(value)
+}
+
+func (murmur3Hasher) Scheme() HashScheme {
+	return HashSchemeMurmur3Entropy
+}
+
+type xxhash64Hasher struct{}
+
+func (xxhash64Hasher) Sum(value []byte) [4]uint64 {
+	return expand64(xxhash64(value, 0))
+}
+
+func (xxhash64Hasher) Scheme() HashScheme {
+	return HashSchemeXXHash64
+}
+
+type wyhashHasher struct{}
+
+func (wyhashHasher) Sum(value []byte) [4]uint64 {
+	return expand64(wyhash(value, 0))
+}
+
+func (wyhashHasher) Scheme() HashScheme {
+	return HashSchemeWyhash
+}
+
+// expand64 expands a 64 bit hash into four words with the splitmix64
+// generator so that they can be used for double hashing.
+func expand64(h uint64) [4]uint64 {
+	const golden = 0x9e3779b97f4a7c15
+	res := [4]uint64{h}
+	for i := 1; i < len(res); i++ {
+		h += golden
+		res[i] = splitmix64(h)
+	}
+	return res
+}
+
+func splitmix64(z uint64) uint64 {
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return z ^ (z >> 31)
+}
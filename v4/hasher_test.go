@@ -0,0 +1,154 @@
+package bloom
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestXXHash64TestSuite(t *testing.T) {
+	for _, test := range []struct {
+		h uint64
+		s string
+	}{
+		// test suite from the xxHash reference implementation
+		{0xef46db3751d8e999, ""},
+		{0xd24ec4f1a98c6e5b, "a"},
+		{0x44bc2cf5ad770999, "abc"},
+		{0xfbcea83c8a378bf1, "Nobody inspects the spammish repetition"},
+	} {
+		assert.Equal(t, test.h, xxhash64([]byte(test.s), 0), test.s)
+	}
+}
+
+func TestWyhashTestSuite(t *testing.T) {
+	// test suite from the wyhash reference implementation, each message
+	// is hashed with its index as the seed
+	for i, test := range []struct {
+		h uint64
+		s string
+	}{
+		{0x93228a4de0eec5a2, ""},
+		{0xc5bac3db178713c4, "a"},
+		{0xa97f2f7b1d9b3314, "abc"},
+		{0x786d1f1df3801df4, "message digest"},
+		{0xdca5a8138ad37c87, "abcdefghijklmnopqrstuvwxyz"},
+		{0xb9e734f117cfaf70, "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789"},
+		{0x6cc5eab49a92d617, "12345678901234567890123456789012345678901234567890123456789012345678901234567890"},
+	} {
+		assert.Equal(t, test.h, wyhash([]byte(test.s), uint64(i)), test.s)
+	}
+}
+
+func TestHashers(t *testing.T) {
+	for _, hasher := range []Hasher{Murmur3Hasher, XXHash64Hasher, WyhashHasher} {
+		resolved, err := HasherForScheme(hasher.Scheme())
+		require.NoError(t, err)
+		require.Equal(t, hasher, resolved)
+
+		m, k := EstimateFalsePositiveRate(1000, 0.01)
+		f := NewBloomFilterWithHasher(m, k, hasher)
+		require.Equal(t, hasher, f.Hasher())
+		n1 := []byte("Bess")
+		n2 := []byte("Jane")
+		f.Add(n1)
+		require.True(t, f.Test(n1))
+		require.False(t, f.Test(n2))
+
+		buf := bytes.NewBuffer(nil)
+		_, err = f.WriteTo(buf)
+		require.NoError(t, err)
+
+		ro, err := NewReadOnlyBloomFilterFromBytes(buf.Bytes(), ParseOptions{})
+		require.NoError(t, err)
+		require.Equal(t, hasher, ro.Hasher())
+		require.True(t, ro.Test(n1))
+		require.False(t, ro.Test(n2))
+
+		cro, err := NewConcurrentReadOnlyBloomFilterFromBytes(buf.Bytes(), ParseOptions{
+			Hasher: hasher,
+		})
+		require.NoError(t, err)
+		require.Equal(t, hasher, cro.Hasher())
+		require.True(t, cro.Test(n1))
+		require.False(t, cro.Test(n2))
+
+		var r BloomFilter
+		_, err = r.ReadFrom(bytes.NewReader(buf.Bytes()))
+		require.NoError(t, err)
+		require.Equal(t, hasher, r.Hasher())
+		require.True(t, r.Test(n1))
+	}
+}
+
+func TestHasherMismatch(t *testing.T) {
+	f := NewBloomFilterWithHasher(1000, 4, XXHash64Hasher)
+	buf := bytes.NewBuffer(nil)
+	_, err := f.WriteTo(buf)
+	require.NoError(t, err)
+
+	_, err = NewReadOnlyBloomFilterFromBytes(buf.Bytes(), ParseOptions{
+		Hasher: Murmur3Hasher,
+	})
+	require.True(t, errors.Is(err, ErrHashSchemeMismatch), "unexpected error: %v", err)
+
+	r := NewBloomFilterWithHasher(1000, 4, WyhashHasher)
+	_, err = r.ReadFrom(bytes.NewReader(buf.Bytes()))
+	require.True(t, errors.Is(err, ErrHashSchemeMismatch), "unexpected error: %v", err)
+
+	_, err = HasherForScheme(HashScheme(0))
+	require.True(t, errors.Is(err, ErrUnsupportedHashScheme), "unexpected error: %v", err)
+}
+
+func TestNilHasher(t *testing.T) {
+	n1 := []byte("Bess")
+	require.Equal(t, Hash(n1), HashWith(nil, n1))
+
+	f := NewBloomFilterWithHasher(1000, 4, nil)
+	require.Equal(t, Murmur3Hasher, f.Hasher())
+	f.Add(n1)
+	require.True(t, f.Test(n1))
+
+	data := f.bitsData()
+	ro := NewReadOnlyBloomFilterWithHasher(1000, 4, data, nil)
+	require.Equal(t, Murmur3Hasher, ro.Hasher())
+	require.True(t, ro.Test(n1))
+	cro := NewConcurrentReadOnlyBloomFilterWithHasher(1000, 4, data, nil)
+	require.Equal(t, Murmur3Hasher, cro.Hasher())
+	require.True(t, cro.Test(n1))
+
+	filters := []interface {
+		Filter
+		Hasher() Hasher
+	}{
+		NewConcurrentBloomFilterWithHasher(1000, 4, nil),
+		NewBlockedBloomFilterWithHasher(1000, 4, nil),
+		NewPartitionedBloomFilterWithHasher(1000, 4, nil),
+	}
+	for _, f := range filters {
+		require.Equal(t, Murmur3Hasher, f.Hasher())
+		f.Add(n1)
+		require.True(t, f.Test(n1))
+	}
+
+	fuse, err := BuildFuseFilterWithHasher([][]byte{n1}, nil)
+	require.NoError(t, err)
+	require.True(t, fuse.Test(n1))
+}
+
+func BenchmarkXXHash64Hasher(b *testing.B) {
+	buf := []byte(_benchStr)
+	for i := 0; i < b.N; i++ {
+		_ = XXHash64Hasher.Sum(buf)
+	}
+}
+
+func BenchmarkWyhashHasher(b *testing.B) {
+	buf := []byte(_benchStr)
+	for i := 0; i < b.N; i++ {
+		_ = WyhashHasher.Sum(buf)
+	}
+}
@@ -0,0 +1,70 @@
+package bloom
+
+import (
+	"encoding/binary"
+	"math"
+	"math/bits"
+)
+
+// Intersect returns a new bloom filter approximating the intersection of
+// the set with the set of other by combining their bits, other must have
+// the same m, k and hash scheme. Every value in both sets tests positive
+// in the result, but the result has at least as many bits set as a filter
+// built from just the values in both sets, so it has a higher false
+// positive rate, see EstimateIntersectionFalsePositiveRate.
+func (b *BloomFilter) Intersect(other Mergeable) (*BloomFilter, error) {
+	if err := checkCompatible(b, other); err != nil {
+		return nil, err
+	}
+	data := append([]byte(nil), b.bitsData()...)
+	andBytes(data, other.bitsData())
+	return &BloomFilter{
+		m:      b.m,
+		k:      b.k,
+		set:    bitSetFromBytes(b.m, data),
+		hasher: b.hasher,
+	}, nil
+}
+
+// EstimateIntersectionFalsePositiveRate estimates the false positive rate
+// of the intersection of two compatible filters returned by Intersect for
+// values in neither set. The fraction of bits set in both filters is the
+// sum of the popcounts of the filters less the popcount of their union,
+// and a value tests positive when all of its k bits are among them.
+// Values in only one of the sets are more likely to be false positives.
+func EstimateIntersectionFalsePositiveRate(a, b Mergeable) (float64, error) {
+	if err := checkCompatible(a, b); err != nil {
+		return 0, err
+	}
+	aData, bData := a.bitsData(), b.bitsData()
+	union := append([]byte(nil), aData...)
+	orBytes(union, bData)
+
+	both := popcount(aData) + popcount(bData) - popcount(union)
+	fill := float64(both) / float64(a.M())
+	return math.Pow(fill, float64(a.K())), nil
+}
+
+// andBytes sets dst to the bitwise and of dst and src.
+func andBytes(dst, src []byte) {
+	for i := range dst {
+		if i < len(src) {
+			dst[i] &= src[i]
+		} else {
+			dst[i] = 0
+		}
+	}
+}
+
+// popcount returns the number of bits set in data.
+func popcount(data []byte) uint64 {
+	var n int
+	for len(data) >= 8 {
+		n += bits.OnesCount64(binary.LittleEndian.Uint64(data))
+		data = data[8:]
+	}
+	for _, v := range data {
+		n += bits.OnesCount8(v)
+	}
+	return uint64(n)
+}
@@ -0,0 +1,58 @@
+package bloom
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIntersect(t *testing.T) {
+	const n = 1000
+	m, k := EstimateFalsePositiveRate(2*n, 0.01)
+	a := NewBloomFilter(m, k)
+	b := NewBloomFilter(m, k)
+	value := func(i int) []byte {
+		return []byte(fmt.Sprintf("series-%d", i))
+	}
+	// a has [0, 2n), b has [n, 3n), so they have [n, 2n) in common.
+	for i := 0; i < 2*n; i++ {
+		a.Add(value(i))
+		b.Add(value(i + n))
+	}
+
+	c, err := a.Intersect(b)
+	require.NoError(t, err)
+	require.Equal(t, a.M(), c.M())
+	require.Equal(t, a.K(), c.K())
+	for i := n; i < 2*n; i++ {
+		require.True(t, c.Test(value(i)))
+	}
+
+	fpr, err := EstimateIntersectionFalsePositiveRate(a, b)
+	require.NoError(t, err)
+	require.True(t, fpr > 0 && fpr < 0.05, "unexpected fpr: %v", fpr)
+
+	// The observed rate for values in neither set is close to the estimate.
+	falsePositives := 0
+	const trials = 100000
+	for i := 0; i < trials; i++ {
+		if c.Test(value(10*n + i)) {
+			falsePositives++
+		}
+	}
+	observed := float64(falsePositives) / trials
+	require.InDelta(t, fpr, observed, 0.005)
+
+	_, err = a.Intersect(NewBloomFilter(m+1, k))
+	var incompatible *IncompatibleFiltersError
+	require.True(t, errors.As(err, &incompatible), "unexpected error: %v", err)
+	_, err = EstimateIntersectionFalsePositiveRate(a, NewBloomFilter(m, k+1))
+	require.True(t, errors.As(err, &incompatible), "unexpected error: %v", err)
+}
+
+func TestPopcount(t *testing.T) {
+	require.Equal(t, uint64(0), popcount(nil))
+	require.Equal(t, uint64(8+1+2), popcount([]byte{0xff, 0, 0, 0, 0, 0, 0, 0x80, 0x3}))
+}
@@ -0,0 +1,107 @@
+package bloom
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// ErrMmapUnsupported is returned when opening a mmap'd filter on a platform
+// that does not support it.
+var ErrMmapUnsupported = errors.New("bloom: mmap is not supported on this platform")
+
+// Advice is a hint to the kernel about how a mmap'd filter will be accessed.
+type Advice int
+
+const (
+	// AdviceNormal gives no hint about how the filter will be accessed.
+	AdviceNormal Advice = iota
+	// AdviceRandom hints that the filter will be accessed randomly, so
+	// read ahead of pages is not useful.
+	AdviceRandom
+	// AdviceWillNeed hints that the filter will be accessed soon, so
+	// pages should be read ahead.
+	AdviceWillNeed
+)
+
+// OpenOptions are options for opening a mmap'd bloom filter file.
+type OpenOptions struct {
+	// Advice is the hint given to the kernel about how the mapping
+	// will be accessed.
+	Advice Advice
+	// Prefault faults in all pages of the mapping when it is opened so
+	// that queries do not incur page faults.
+	Prefault bool
+	// VerifyChecksum verifies the checksum of the filter when it is opened.
+	VerifyChecksum bool
+	// Hasher is the hasher the filter must have been built with, if nil
+	// the filter may have been built with any of the built in hashers.
+	Hasher Hasher
+}
+
+// MappedBloomFilter is a concurrent read only bloom filter backed by a
+// read only mmap of a file written with BloomFilter.WriteTo. It can be
+// concurrently read from by any number of readers, but must not be read
+// from after Close is called.
+type MappedBloomFilter struct {
+	*ConcurrentReadOnlyBloomFilter
+	data []byte
+}
+
+// OpenFile mmaps the bloom filter file at path read only and returns
+// a concurrent read only bloom filter backed by the mapping.
+func OpenFile(path string, opts OpenOptions) (*MappedBloomFilter, error) {
+	data, err := mapFile(path, false, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	filter, err := NewConcurrentReadOnlyBloomFilterFromBytes(data, ParseOptions{
+		VerifyChecksum: opts.VerifyChecksum,
+		Hasher:         opts.Hasher,
+	})
+	if err != nil {
+		munmap(data)
+		return nil, err
+	}
+
+	return &MappedBloomFilter{
+		ConcurrentReadOnlyBloomFilter: filter,
+		data:                          data,
+	}, nil
+}
+
+// Close unmaps the file backing the bloom filter.
+func (b *MappedBloomFilter) Close() error {
+	if b.data == nil {
+		return nil
+	}
+	data := b.data
+	b.data = nil
+	return munmap(data)
+}
+
+func mapFile(path string, writable bool, opts OpenOptions) ([]byte, error) {
+	flag := os.O_RDONLY
+	if writable {
+		flag = os.O_RDWR
+	}
+	f, err := os.OpenFile(path, flag, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size := info.Size()
+	if size < headerLen {
+		return nil, ErrTruncated
+	}
+	if int64(int(size)) != size {
+		return nil, fmt.Errorf("bloom: file too large to mmap: %d bytes", size)
+	}
+	return mmap(f, int(size), writable, opts)
+}
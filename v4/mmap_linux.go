@@ -0,0 +1,68 @@
+//go:build linux
+// +build linux
+
+package bloom
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+func mmap(f *os.File, size int, writable bool, opts OpenOptions) ([]byte, error) {
+	prot := syscall.PROT_READ
+	if writable {
+		prot |= syscall.PROT_WRITE
+	}
+	flags := syscall.MAP_SHARED
+	if opts.Prefault {
+		flags |= syscall.MAP_POPULATE
+	}
+	data, err := syscall.Mmap(int(f.Fd()), 0, size, prot, flags)
+	if err != nil {
+		return nil, fmt.Errorf("bloom: mmap failed: %v", err)
+	}
+	if err := madvise(data, opts.Advice); err != nil {
+		munmap(data)
+		return nil, err
+	}
+	return data, nil
+}
+
+func madvise(data []byte, advice Advice) error {
+	var flag int
+	switch advice {
+	case AdviceNormal:
+		return nil
+	case AdviceRandom:
+		flag = syscall.MADV_RANDOM
+	case AdviceWillNeed:
+		flag = syscall.MADV_WILLNEED
+	default:
+		return fmt.Errorf("bloom: unknown mmap advice: %d", advice)
+	}
+	if err := syscall.Madvise(data, flag); err != nil {
+		return fmt.Errorf("bloom: madvise failed: %v", err)
+	}
+	return nil
+}
+
+func munmap(data []byte) error {
+	if err := syscall.Munmap(data); err != nil {
+		return fmt.Errorf("bloom: munmap failed: %v", err)
+	}
+	return nil
+}
+
+func msync(data []byte) error {
+	if len(data) == 0 {
+		return nil
+	}
+	_, _, errno := syscall.Syscall(syscall.SYS_MSYNC,
+		uintptr(unsafe.Pointer(&data[0])), uintptr(len(data)), syscall.MS_SYNC)
+	if errno != 0 {
+		return fmt.Errorf("bloom: msync failed: %v", errno)
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+//go:build linux
+// +build linux
+
+package bloom
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func writeTestFile(t *testing.T, dir string, f *BloomFilter) string {
+	path := filepath.Join(dir, "bloom.db")
+	fd, err := os.Create(path)
+	require.NoError(t, err)
+	_, err = f.WriteTo(fd)
+	require.NoError(t, err)
+	require.NoError(t, fd.Close())
+	return path
+}
+
+func TestOpenFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bloom")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	f := NewBloomFilter(1000, 4)
+	n1 := []byte("Bess")
+	n2 := []byte("Jane")
+	f.Add(n1)
+	path := writeTestFile(t, dir, f)
+
+	for _, opts := range []OpenOptions{
+		{},
+		{Advice: AdviceRandom, VerifyChecksum: true},
+		{Advice: AdviceWillNeed, Prefault: true},
+	} {
+		mapped, err := OpenFile(path, opts)
+		require.NoError(t, err)
+		require.Equal(t, f.M(), mapped.M())
+		require.Equal(t, f.K(), mapped.K())
+		require.True(t, mapped.Test(n1))
+		require.False(t, mapped.Test(n2))
+		require.NoError(t, mapped.Verify())
+		require.NoError(t, mapped.Close())
+		require.NoError(t, mapped.Close())
+	}
+}
+
+func TestOpenFileErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bloom")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	_, err = OpenFile(filepath.Join(dir, "missing"), OpenOptions{})
+	require.True(t, os.IsNotExist(err))
+
+	f := NewBloomFilter(1000, 4)
+	path := writeTestFile(t, dir, f)
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	require.NoError(t, os.Truncate(path, info.Size()-1))
+
+	_, err = OpenFile(path, OpenOptions{})
+	require.True(t, errors.Is(err, ErrTruncated), "unexpected error: %v", err)
+
+	require.NoError(t, os.Truncate(path, 0))
+	_, err = OpenFile(path, OpenOptions{})
+	require.True(t, errors.Is(err, ErrTruncated), "unexpected error: %v", err)
+}
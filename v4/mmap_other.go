@@ -0,0 +1,18 @@
+//go:build !linux
+// +build !linux
+
+package bloom
+
+import "os"
+
+func mmap(f *os.File, size int, writable bool, opts OpenOptions) ([]byte, error) {
+	return nil, ErrMmapUnsupported
+}
+
+func munmap(data []byte) error {
+	return ErrMmapUnsupported
+}
+
+func msync(data []byte) error {
+	return ErrMmapUnsupported
+}
@@ -0,0 +1,64 @@
+package bloom
+
+import "encoding/binary"
+
+// maxPackedWidth is the widest value a packedArray can hold.
+const maxPackedWidth = 32
+
+// packedArray is an array of unsigned values of a fixed width of 1 to 32
+// bits packed into a little endian byte slice, value i occupies bits
+// [i*width, (i+1)*width) of the slice.
+type packedArray struct {
+	data  []byte
+	width uint64
+	mask  uint64
+}
+
+// newPackedArray returns a packed array of n zero values of a width.
+func newPackedArray(n uint64, width uint) packedArray {
+	return newPackedArrayFromBytes(make([]byte, packedArrayBytesLen(n, width)), width)
+}
+
+// newPackedArrayFromBytes returns a packed array of a width backed by data.
+func newPackedArrayFromBytes(data []byte, width uint) packedArray {
+	return packedArray{
+		data:  data,
+		width: uint64(width),
+		mask:  1<<width - 1,
+	}
+}
+
+// packedArrayBytesLen returns the number of bytes n values of a width
+// occupy when packed.
+func packedArrayBytesLen(n uint64, width uint) uint64 {
+	return (n*uint64(width) + 7) / 8
+}
+
+func (a packedArray) get(i uint64) uint32 {
+	off := i * a.width
+	idx, shift := off>>3, off&7
+	if idx+8 <= uint64(len(a.data)) {
+		return uint32(binary.LittleEndian.Uint64(a.data[idx:]) >> shift & a.mask)
+	}
+	var word uint64
+	for j := uint64(0); idx+j < uint64(len(a.data)) && j < 8; j++ {
+		word |= uint64(a.data[idx+j]) << (8 * j)
+	}
+	return uint32(word >> shift & a.mask)
+}
+
+func (a packedArray) set(i uint64, v uint32) {
+	off := i * a.width
+	idx, shift := off>>3, off&7
+	value := (uint64(v) & a.mask) << shift
+	mask := a.mask << shift
+	if idx+8 <= uint64(len(a.data)) {
+		word := binary.LittleEndian.Uint64(a.data[idx:])
+		binary.LittleEndian.PutUint64(a.data[idx:], word&^mask|value)
+		return
+	}
+	for j := uint64(0); idx+j < uint64(len(a.data)) && j < 8; j++ {
+		b := uint(8 * j)
+		a.data[idx+j] = a.data[idx+j]&^byte(mask>>b) | byte(value>>b)
+	}
+}
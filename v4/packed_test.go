@@ -0,0 +1,32 @@
+package bloom
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPackedArray(t *testing.T) {
+	for width := uint(1); width <= maxPackedWidth; width++ {
+		const n = 100
+		a := newPackedArray(n, width)
+		require.Equal(t, int(packedArrayBytesLen(n, width)), len(a.data))
+
+		expected := make([]uint32, n)
+		for i := range expected {
+			expected[i] = uint32(rand.Uint64() & (1<<width - 1))
+			a.set(uint64(i), expected[i])
+		}
+		for i := range expected {
+			require.Equal(t, expected[i], a.get(uint64(i)), "width %d index %d", width, i)
+		}
+
+		// Overwriting a value does not change its neighbours.
+		a.set(n/2, 0)
+		expected[n/2] = 0
+		for i := range expected {
+			require.Equal(t, expected[i], a.get(uint64(i)), "width %d index %d", width, i)
+		}
+	}
+}
@@ -0,0 +1,357 @@
+package bloom
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/bits"
+)
+
+// partitionedHeaderMagic identifies a serialized partitioned bloom filter,
+// it is "M3PB" when read as little endian bytes.
+const partitionedHeaderMagic uint32 = 0x4250334d
+
+// maxPartitionedM is the largest m of a partitioned bloom filter, rounding
+// a larger m up to a whole number of words would overflow.
+const maxPartitionedM = math.MaxUint64 - 63
+
+// ErrUnevenPartitions is returned when parsing a partitioned bloom filter
+// with an m that is not a multiple of k.
+var ErrUnevenPartitions = errors.New("bloom: m is not a multiple of k")
+
+// partitionedLayout is the layout of the partitioned bloom filters.
+var partitionedLayout = layout{
+	magic:      partitionedHeaderMagic,
+	payloadLen: partitionedBytesLen,
+	maxM:       maxPartitionedM,
+}
+
+// partitionedBytesLen returns the number of bytes of a partitioned bloom
+// filter of m bits, stored as 64 bit words.
+func partitionedBytesLen(m uint64) uint64 {
+	return (m + 63) / 64 * 8
+}
+
+// PartitionedStats are statistics of a partitioned bloom filter computed
+// from its bits.
+type PartitionedStats struct {
+	Stats
+
+	// SliceBitsSet is the number of bits set in each of the k slices.
+	SliceBitsSet []uint64
+}
+
+// PartitionedBloomFilter is a bloom filter set membership that splits its
+// m bits into k disjoint slices of m/k bits, each hash sets exactly one bit
+// in its own slice. Values never share a bit between different hashes so
+// the false positive rate is the product of the fill ratios of the slices,
+// which is more predictable than that of BloomFilter, and the fill of each
+// slice can be inspected with Stats. m is rounded up to a multiple of k.
+// It cannot be concurrently read or written to, a sync.Mutex must be used
+// to guard read/write access if desired.
+type PartitionedBloomFilter struct {
+	m      uint64
+	k      uint64
+	words  []uint64
+	hasher Hasher
+}
+
+// NewPartitionedBloomFilter creates a new partitioned bloom filter that can
+// represent m elements with k hashes. It is not concurrent read or write
+// safe.
+func NewPartitionedBloomFilter(m uint, k uint) *PartitionedBloomFilter {
+	return NewPartitionedBloomFilterWithHasher(m, k, Murmur3Hasher)
+}
+
+// NewPartitionedBloomFilterWithHasher creates a new partitioned bloom
+// filter that can represent m elements with k hashes using a hasher, or
+// Murmur3Hasher if nil. It is not concurrent read or write safe.
+func NewPartitionedBloomFilterWithHasher(
+	m uint,
+	k uint,
+	hasher Hasher,
+) *PartitionedBloomFilter {
+	hasher = hasherOrDefault(hasher)
+	if m < 1 {
+		m = 1
+	}
+	if k < 1 {
+		k = 1
+	}
+	slice := (uint64(m) + uint64(k) - 1) / uint64(k)
+	total := slice * uint64(k)
+	return &PartitionedBloomFilter{
+		m:      total,
+		k:      uint64(k),
+		words:  make([]uint64, partitionedBytesLen(total)/8),
+		hasher: hasher,
+	}
+}
+
+// partitionedLocation returns the location of the i-th bit of a digest,
+// which is within the i-th slice of slice bits.
+func partitionedLocation(h Digest, i, slice uint64) uint64 {
+	return i*slice + uint64(bloomFilterLocation(h, i, slice))
+}
+
+// Add value to the set.
+func (b *PartitionedBloomFilter) Add(value []byte) {
+	b.AddDigest(Digest(b.hasher.Sum(value)))
+}
+
+// AddDigest adds the value with a digest to the set, the digest must
+// have been computed with the hasher of the filter.
+func (b *PartitionedBloomFilter) AddDigest(h Digest) {
+	slice := b.m / b.k
+	for i := uint64(0); i < b.k; i++ {
+		loc := partitionedLocation(h, i, slice)
+		b.words[loc>>6] |= 1 << (loc & 63)
+	}
+}
+
+// Test if value is in the set.
+func (b *PartitionedBloomFilter) Test(value []byte) bool {
+	return b.TestDigest(Digest(b.hasher.Sum(value)))
+}
+
+// TestDigest tests if the value with a digest is in the set, the
+// digest must have been computed with the hasher of the filter.
+func (b *PartitionedBloomFilter) TestDigest(h Digest) bool {
+	slice := b.m / b.k
+	for i := uint64(0); i < b.k; i++ {
+		loc := partitionedLocation(h, i, slice)
+		if b.words[loc>>6]&(1<<(loc&63)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// M returns the m elements represented.
+func (b *PartitionedBloomFilter) M() uint {
+	return uint(b.m)
+}
+
+// K returns the k hashes used.
+func (b *PartitionedBloomFilter) K() uint {
+	return uint(b.k)
+}
+
+// Hasher returns the hasher used.
+func (b *PartitionedBloomFilter) Hasher() Hasher {
+	return b.hasher
+}
+
+// Stats returns statistics of the filter computed from its bits.
+func (b *PartitionedBloomFilter) Stats() PartitionedStats {
+	return newPartitionedStats(b.m, b.k, b.bytes())
+}
+
+// WriteTo writes the partitioned bloom filter to a stream with a header
+// describing m, k and the hash scheme.
+func (b *PartitionedBloomFilter) WriteTo(w io.Writer) (int64, error) {
+	return partitionedLayout.write(w, b.m, b.k, b.hasher, b.bytes())
+}
+
+// ReadFrom reads a partitioned bloom filter previously written with
+// WriteTo from a stream, replacing the contents of the partitioned bloom
+// filter. If the filter has a hasher the stream must have been written
+// with the same hasher.
+func (b *PartitionedBloomFilter) ReadFrom(r io.Reader) (int64, error) {
+	h, payload, hasher, read, err := partitionedLayout.read(r, b.hasher)
+	if err != nil {
+		return read, err
+	}
+	if err := validatePartitions(h, payload); err != nil {
+		return read, err
+	}
+	words := make([]uint64, len(payload)/8)
+	for i := range words {
+		words[i] = binary.LittleEndian.Uint64(payload[8*i:])
+	}
+	b.m = h.M
+	b.k = h.K
+	b.words = words
+	b.hasher = hasher
+	return read, nil
+}
+
+func (b *PartitionedBloomFilter) bytes() []byte {
+	data := make([]byte, 8*len(b.words))
+	for i, w := range b.words {
+		binary.LittleEndian.PutUint64(data[8*i:], w)
+	}
+	return data
+}
+
+// ReadOnlyPartitionedBloomFilter is a read only partitioned bloom filter
+// set membership backed by a byte slice, this means it can be used with a
+// mmap'd bytes ref. It is not concurrent read or write safe.
+type ReadOnlyPartitionedBloomFilter struct {
+	readOnlyPartitioned
+}
+
+// NewReadOnlyPartitionedBloomFilterFromBytes returns a new read only
+// partitioned bloom filter from data previously written with
+// PartitionedBloomFilter.WriteTo, the bits are not copied so data can be a
+// mmap'd bytes ref. It is not concurrent read or write safe.
+func NewReadOnlyPartitionedBloomFilterFromBytes(
+	data []byte,
+	opts ParseOptions,
+) (*ReadOnlyPartitionedBloomFilter, error) {
+	b, err := newReadOnlyPartitioned(data, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &ReadOnlyPartitionedBloomFilter{readOnlyPartitioned: b}, nil
+}
+
+// ConcurrentReadOnlyPartitionedBloomFilter is a concurrent read only
+// partitioned bloom filter set membership backed by a byte slice, this
+// means it can be used with a mmap'd bytes ref. It can be concurrently
+// read from by any number of readers.
+type ConcurrentReadOnlyPartitionedBloomFilter struct {
+	readOnlyPartitioned
+}
+
+// NewConcurrentReadOnlyPartitionedBloomFilterFromBytes returns a new
+// concurrent read only partitioned bloom filter from data previously
+// written with PartitionedBloomFilter.WriteTo, the bits are not copied so
+// data can be a mmap'd bytes ref. It can be concurrently read from by any
+// number of readers.
+func NewConcurrentReadOnlyPartitionedBloomFilterFromBytes(
+	data []byte,
+	opts ParseOptions,
+) (*ConcurrentReadOnlyPartitionedBloomFilter, error) {
+	b, err := newReadOnlyPartitioned(data, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &ConcurrentReadOnlyPartitionedBloomFilter{readOnlyPartitioned: b}, nil
+}
+
+// readOnlyPartitioned implements the read only partitioned bloom filters,
+// which only differ in their documented concurrency guarantees.
+type readOnlyPartitioned struct {
+	m        uint64
+	k        uint64
+	data     []byte
+	hasher   Hasher
+	checksum *storedChecksum
+}
+
+func newReadOnlyPartitioned(
+	data []byte,
+	opts ParseOptions,
+) (readOnlyPartitioned, error) {
+	h, payload, hasher, sum, err := partitionedLayout.parsePayload(data, opts)
+	if err != nil {
+		return readOnlyPartitioned{}, err
+	}
+	if err := validatePartitions(h, payload); err != nil {
+		return readOnlyPartitioned{}, err
+	}
+	return readOnlyPartitioned{
+		m:        h.M,
+		k:        h.K,
+		data:     payload,
+		hasher:   hasher,
+		checksum: sum,
+	}, nil
+}
+
+// Test if value is in the set.
+func (b *readOnlyPartitioned) Test(value []byte) bool {
+	return b.TestDigest(Digest(b.hasher.Sum(value)))
+}
+
+// TestDigest tests if the value with a digest is in the set, the
+// digest must have been computed with the hasher of the filter.
+func (b *readOnlyPartitioned) TestDigest(h Digest) bool {
+	slice := b.m / b.k
+	for i := uint64(0); i < b.k; i++ {
+		loc := partitionedLocation(h, i, slice)
+		if b.data[loc>>3]&(1<<(loc&7)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// M returns the m elements represented.
+func (b *readOnlyPartitioned) M() uint {
+	return uint(b.m)
+}
+
+// K returns the k hashes used.
+func (b *readOnlyPartitioned) K() uint {
+	return uint(b.k)
+}
+
+// Hasher returns the hasher used.
+func (b *readOnlyPartitioned) Hasher() Hasher {
+	return b.hasher
+}
+
+// Stats returns statistics of the filter computed from its bits.
+func (b *readOnlyPartitioned) Stats() PartitionedStats {
+	return newPartitionedStats(b.m, b.k, b.data)
+}
+
+// Verify verifies the bits of the filter against the checksum stored when
+// it was serialized.
+func (b *readOnlyPartitioned) Verify() error {
+	return b.checksum.verify()
+}
+
+func validatePartitions(h Header, payload []byte) error {
+	if h.M%h.K != 0 {
+		return fmt.Errorf("%w: m %d, k %d", ErrUnevenPartitions, h.M, h.K)
+	}
+	// Every bit of m must be within the payload.
+	if (h.M-1)/8 >= uint64(len(payload)) {
+		return fmt.Errorf("%w: m=%d, payload=%d", ErrPayloadLength, h.M, len(payload))
+	}
+	return nil
+}
+
+// newPartitionedStats returns the statistics of a partitioned bloom filter
+// of m bits and k slices. The false positive rate is the product of the
+// fill ratios of the slices and the estimated count is the mean of the
+// counts estimated from each slice.
+func newPartitionedStats(m, k uint64, data []byte) PartitionedStats {
+	slice := m / k
+	stats := PartitionedStats{
+		Stats:        newStats(m, k, data),
+		SliceBitsSet: make([]uint64, k),
+	}
+	stats.FalsePositiveRate = 1
+	stats.EstimatedCount = 0
+	for i := range stats.SliceBitsSet {
+		set := popcountRange(data, uint64(i)*slice, uint64(i+1)*slice)
+		stats.SliceBitsSet[i] = set
+		stats.FalsePositiveRate *= float64(set) / float64(slice)
+		stats.EstimatedCount += estimateCount(slice, 1, set)
+	}
+	stats.EstimatedCount /= float64(k)
+	return stats
+}
+
+// popcountRange returns the number of bits set in data from bit start up
+// to but not including bit end.
+func popcountRange(data []byte, start, end uint64) uint64 {
+	if start >= end {
+		return 0
+	}
+	first, last := start>>3, (end-1)>>3
+	if first == last {
+		mask := byte(0xff<<(start&7)) & byte(0xff>>(7-(end-1)&7))
+		return uint64(bits.OnesCount8(data[first] & mask))
+	}
+	n := uint64(bits.OnesCount8(data[first] & byte(0xff<<(start&7))))
+	n += popcount(data[first+1 : last])
+	n += uint64(bits.OnesCount8(data[last] & byte(0xff>>(7-(end-1)&7))))
+	return n
+}
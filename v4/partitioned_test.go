@@ -0,0 +1,167 @@
+package bloom
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPartitionedBloomFilter(t *testing.T) {
+	f := NewPartitionedBloomFilter(1000, 3)
+	n1 := []byte("Bess")
+	n2 := []byte("Jane")
+	n3 := []byte("Emma")
+	f.Add(n1)
+	f.Add(n3)
+
+	require.Equal(t, uint(1002), f.M())
+	require.Equal(t, uint(3), f.K())
+	require.True(t, f.Test(n1))
+	require.False(t, f.Test(n2))
+	require.True(t, f.Test(n3))
+	require.True(t, f.TestDigest(Hash(n1)))
+}
+
+func TestPartitionedBloomFilterOneBitPerSlice(t *testing.T) {
+	f := NewPartitionedBloomFilter(1000, 5)
+	var buff [8]byte
+	for i := 0; i < 100; i++ {
+		endianness.PutUint64(buff[:], uint64(i))
+		f.Add(buff[:])
+		for _, set := range f.Stats().SliceBitsSet {
+			// Each value sets at most one bit of each slice.
+			require.True(t, set <= uint64(i+1))
+		}
+	}
+
+	stats := f.Stats()
+	require.Len(t, stats.SliceBitsSet, 5)
+	var total uint64
+	for _, set := range stats.SliceBitsSet {
+		require.True(t, set > 0 && set <= 100, "slice bits set: %d", set)
+		total += set
+	}
+	require.Equal(t, total, stats.BitsSet)
+	require.InDelta(t, 100, stats.EstimatedCount, 10)
+
+	expected := 1.0
+	for _, set := range stats.SliceBitsSet {
+		expected *= float64(set) / 200
+	}
+	require.InDelta(t, expected, stats.FalsePositiveRate, 1e-12)
+}
+
+func TestPartitionedBloomFilterFalsePositiveRate(t *testing.T) {
+	const n = 10000
+	plan, err := PlanForFalsePositiveRate(n, 0.01)
+	require.NoError(t, err)
+	f := NewPartitionedBloomFilter(plan.M, plan.K)
+	var buff [8]byte
+	for i := 0; i < n; i++ {
+		endianness.PutUint64(buff[:], uint64(i))
+		f.Add(buff[:])
+	}
+
+	var fp int
+	for i := n; i < 11*n; i++ {
+		endianness.PutUint64(buff[:], uint64(i))
+		if f.Test(buff[:]) {
+			fp++
+		}
+	}
+	actual := float64(fp) / (10 * n)
+	require.InDelta(t, f.Stats().FalsePositiveRate, actual, 0.002)
+}
+
+func TestPartitionedBloomFilterWriteToReadFrom(t *testing.T) {
+	f := NewPartitionedBloomFilterWithHasher(1000, 4, WyhashHasher)
+	n1 := []byte("Bess")
+	n2 := []byte("Jane")
+	f.Add(n1)
+
+	buf := bytes.NewBuffer(nil)
+	written, err := f.WriteTo(buf)
+	require.NoError(t, err)
+	require.Equal(t, int64(buf.Len()), written)
+
+	var r PartitionedBloomFilter
+	read, err := r.ReadFrom(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	require.Equal(t, written, read)
+	require.Equal(t, f.M(), r.M())
+	require.Equal(t, f.K(), r.K())
+	require.Equal(t, HashSchemeWyhash, r.Hasher().Scheme())
+	require.True(t, r.Test(n1))
+	require.False(t, r.Test(n2))
+
+	opts := ParseOptions{VerifyChecksum: true}
+	ro, err := NewReadOnlyPartitionedBloomFilterFromBytes(buf.Bytes(), opts)
+	require.NoError(t, err)
+	require.Equal(t, f.M(), ro.M())
+	require.Equal(t, f.K(), ro.K())
+	require.True(t, ro.Test(n1))
+	require.False(t, ro.Test(n2))
+	require.Equal(t, f.Stats(), ro.Stats())
+	require.NoError(t, ro.Verify())
+
+	cro, err := NewConcurrentReadOnlyPartitionedBloomFilterFromBytes(buf.Bytes(), opts)
+	require.NoError(t, err)
+	require.True(t, cro.Test(n1))
+	require.False(t, cro.Test(n2))
+	require.Equal(t, f.Stats(), cro.Stats())
+	require.NoError(t, cro.Verify())
+}
+
+func TestPartitionedBloomFilterUnevenPartitions(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	_, err := NewPartitionedBloomFilter(1000, 4).WriteTo(buf)
+	require.NoError(t, err)
+	data := buf.Bytes()
+
+	// A k that does not divide m, with a payload length that matches m.
+	data[16] += 2
+	_, err = NewReadOnlyPartitionedBloomFilterFromBytes(data, ParseOptions{})
+	require.True(t, errors.Is(err, ErrUnevenPartitions), "unexpected error: %v", err)
+
+	_, err = NewReadOnlyBloomFilterFromBytes(data, ParseOptions{})
+	require.True(t, errors.Is(err, ErrInvalidMagic), "unexpected error: %v", err)
+}
+
+func TestPartitionedBloomFilterOverflowingM(t *testing.T) {
+	// A header with an m, divisible by k, whose rounded up payload length
+	// wraps around to zero, followed by no payload.
+	var data [headerLen]byte
+	Header{
+		Version:    formatVersion,
+		HashScheme: Murmur3Hasher.Scheme(),
+		M:          math.MaxUint64,
+		K:          5,
+		PayloadLen: 0,
+	}.encode(data[:], partitionedHeaderMagic)
+
+	_, err := NewReadOnlyPartitionedBloomFilterFromBytes(data[:], ParseOptions{})
+	require.True(t, errors.Is(err, ErrPayloadLength), "unexpected error: %v", err)
+	_, err = NewConcurrentReadOnlyPartitionedBloomFilterFromBytes(data[:], ParseOptions{})
+	require.True(t, errors.Is(err, ErrPayloadLength), "unexpected error: %v", err)
+	var r PartitionedBloomFilter
+	_, err = r.ReadFrom(bytes.NewReader(data[:]))
+	require.True(t, errors.Is(err, ErrPayloadLength), "unexpected error: %v", err)
+
+	// A payload too short for the bits of m.
+	require.True(t, errors.Is(validatePartitions(Header{M: 72, K: 4}, make([]byte, 8)),
+		ErrPayloadLength))
+	require.NoError(t, validatePartitions(Header{M: 64, K: 4}, make([]byte, 8)))
+}
+
+func TestPopcountRange(t *testing.T) {
+	data := []byte{0xff, 0x0f, 0xf0, 0xff}
+	require.Equal(t, uint64(0), popcountRange(data, 3, 3))
+	require.Equal(t, uint64(3), popcountRange(data, 1, 4))
+	require.Equal(t, uint64(4), popcountRange(data, 8, 16))
+	require.Equal(t, uint64(6), popcountRange(data, 6, 18))
+	require.Equal(t, uint64(24), popcountRange(data, 0, 32))
+	require.Equal(t, uint64(4), popcountRange(data, 12, 24))
+}
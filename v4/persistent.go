@@ -0,0 +1,203 @@
+package bloom
+
+import (
+	"encoding/binary"
+	"os"
+)
+
+// PersistentBloomFilter is a bloom filter whose bits live in a shared
+// writable mmap of a file in the format written by BloomFilter.WriteTo,
+// so it can be reopened after a restart without being rebuilt and can
+// hold filters larger than is desirable on the heap.
+// It cannot be concurrently read or written to, a sync.Mutex must be used
+// to guard read/write access if desired.
+type PersistentBloomFilter struct {
+	m       uint64
+	k       uint64
+	data    []byte
+	payload []byte
+	hasher  Hasher
+}
+
+// CreatePersistentBloomFilter creates a new file at path holding an empty
+// bloom filter that can represent m elements with k hashes and returns the
+// filter backed by a writable mmap of it. It fails if the file exists.
+func CreatePersistentBloomFilter(
+	path string,
+	m, k uint,
+) (*PersistentBloomFilter, error) {
+	return CreatePersistentBloomFilterWithHasher(path, m, k, Murmur3Hasher)
+}
+
+// CreatePersistentBloomFilterWithHasher creates a new persistent bloom
+// filter like CreatePersistentBloomFilter using a hasher, or Murmur3Hasher
+// if nil.
+func CreatePersistentBloomFilterWithHasher(
+	path string,
+	m, k uint,
+	hasher Hasher,
+) (*PersistentBloomFilter, error) {
+	hasher = hasherOrDefault(hasher)
+	if err := validateParams(uint64(m), uint64(k)); err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
+	if err != nil {
+		return nil, err
+	}
+	b, err := initPersistentBloomFilter(f, uint64(m), uint64(k), hasher)
+	if err != nil {
+		// Remove the partially initialized file, it would otherwise fail
+		// both a retry of the create and an open.
+		os.Remove(path)
+		return nil, err
+	}
+	return b, nil
+}
+
+// initPersistentBloomFilter sizes a newly created file for an empty bloom
+// filter, closing it, and returns the filter backed by a writable mmap of
+// it with its header written and synced.
+func initPersistentBloomFilter(
+	f *os.File,
+	m, k uint64,
+	hasher Hasher,
+) (*PersistentBloomFilter, error) {
+	payloadLen := bitSetBytesLen(m)
+	err := f.Truncate(int64(headerLen + payloadLen))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := mapFile(f.Name(), true, OpenOptions{})
+	if err != nil {
+		return nil, err
+	}
+	Header{
+		Version:    formatVersion,
+		HashScheme: hasher.Scheme(),
+		M:          m,
+		K:          k,
+		PayloadLen: payloadLen,
+	}.encode(data, headerMagic)
+
+	b := &PersistentBloomFilter{
+		m:       m,
+		k:       k,
+		data:    data,
+		payload: data[headerLen:],
+		hasher:  hasher,
+	}
+	if err := b.Sync(); err != nil {
+		munmap(data)
+		return nil, err
+	}
+	return b, nil
+}
+
+// OpenPersistentBloomFilter opens a bloom filter file at path previously
+// created with CreatePersistentBloomFilter or written with
+// BloomFilter.WriteTo and returns the filter backed by a writable mmap
+// of it. The checksum is only updated by Sync and Close, so verifying it
+// fails for a file that was not synced after it was last added to.
+func OpenPersistentBloomFilter(
+	path string,
+	opts OpenOptions,
+) (*PersistentBloomFilter, error) {
+	data, err := mapFile(path, true, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	h, payload, hasher, _, err := bitSetLayout.parsePayload(data, ParseOptions{
+		VerifyChecksum: opts.VerifyChecksum,
+		Hasher:         opts.Hasher,
+	})
+	if err == nil {
+		err = validate(h.M, h.K, uint64(len(payload)))
+	}
+	if err != nil {
+		munmap(data)
+		return nil, err
+	}
+
+	return &PersistentBloomFilter{
+		m:       h.M,
+		k:       h.K,
+		data:    data,
+		payload: payload,
+		hasher:  hasher,
+	}, nil
+}
+
+// Add value to the set.
+func (b *PersistentBloomFilter) Add(value []byte) {
+	b.AddDigest(Digest(b.hasher.Sum(value)))
+}
+
+// AddDigest adds the value with a digest to the set, the digest must
+// have been computed with the hasher of the filter.
+func (b *PersistentBloomFilter) AddDigest(h Digest) {
+	for i := uint64(0); i < b.k; i++ {
+		loc := bloomFilterLocation(h, i, b.m)
+		b.payload[loc/8] |= 1 << (loc % 8)
+	}
+}
+
+// Test if value is in the set.
+func (b *PersistentBloomFilter) Test(value []byte) bool {
+	return b.TestDigest(Digest(b.hasher.Sum(value)))
+}
+
+// TestDigest tests if the value with a digest is in the set, the
+// digest must have been computed with the hasher of the filter.
+func (b *PersistentBloomFilter) TestDigest(h Digest) bool {
+	for i := uint64(0); i < b.k; i++ {
+		loc := bloomFilterLocation(h, i, b.m)
+		if b.payload[loc/8]&(1<<(loc%8)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// M returns the m elements represented.
+func (b *PersistentBloomFilter) M() uint {
+	return uint(b.m)
+}
+
+// K returns the k hashes used.
+func (b *PersistentBloomFilter) K() uint {
+	return uint(b.k)
+}
+
+// Hasher returns the hasher used.
+func (b *PersistentBloomFilter) Hasher() Hasher {
+	return b.hasher
+}
+
+// Sync updates the checksum of the file and flushes the mapping to disk.
+func (b *PersistentBloomFilter) Sync() error {
+	sum := checksum(b.data[:checksumOffset], b.payload)
+	binary.LittleEndian.PutUint32(b.data[checksumOffset:], sum)
+	return msync(b.data)
+}
+
+// Close syncs the filter and unmaps the file backing it, the filter must
+// not be used after it is closed.
+func (b *PersistentBloomFilter) Close() error {
+	if b.data == nil {
+		return nil
+	}
+	err := b.Sync()
+	if unmapErr := munmap(b.data); err == nil {
+		err = unmapErr
+	}
+	b.data = nil
+	b.payload = nil
+	return err
+}
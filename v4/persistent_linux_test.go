@@ -0,0 +1,113 @@
+//go:build linux
+// +build linux
+
+package bloom
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPersistentBloomFilter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bloom")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "bloom.db")
+	m, k := EstimateFalsePositiveRate(1000, 0.01)
+	n1 := []byte("Bess")
+	n2 := []byte("Jane")
+	n3 := []byte("Emma")
+
+	f, err := CreatePersistentBloomFilter(path, m, k)
+	require.NoError(t, err)
+	require.Equal(t, m, f.M())
+	require.Equal(t, k, f.K())
+	f.Add(n1)
+	f.Add(n3)
+	require.True(t, f.Test(n1))
+	require.False(t, f.Test(n2))
+	require.NoError(t, f.Close())
+	require.NoError(t, f.Close())
+
+	_, err = CreatePersistentBloomFilter(path, m, k)
+	require.True(t, os.IsExist(err))
+
+	f, err = OpenPersistentBloomFilter(path, OpenOptions{VerifyChecksum: true})
+	require.NoError(t, err)
+	require.Equal(t, m, f.M())
+	require.Equal(t, k, f.K())
+	require.True(t, f.Test(n1))
+	require.False(t, f.Test(n2))
+	require.True(t, f.Test(n3))
+	f.Add(n2)
+	require.NoError(t, f.Sync())
+
+	// The file is readable by the other readers of the format.
+	data, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	var r BloomFilter
+	_, err = r.ReadFrom(bytes.NewReader(data))
+	require.NoError(t, err)
+	require.True(t, r.Test(n1))
+	require.True(t, r.Test(n2))
+
+	mapped, err := OpenFile(path, OpenOptions{VerifyChecksum: true})
+	require.NoError(t, err)
+	require.True(t, mapped.Test(n2))
+	require.NoError(t, mapped.Close())
+
+	// Adds after the last sync leave a stale checksum.
+	f.Add([]byte("Love"))
+	_, err = OpenPersistentBloomFilter(path, OpenOptions{VerifyChecksum: true})
+	require.True(t, errors.Is(err, ErrChecksumMismatch), "unexpected error: %v", err)
+	require.NoError(t, f.Close())
+}
+
+func TestCreatePersistentBloomFilterFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bloom")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	if ^uint(0)>>32 == 0 {
+		t.Skip("a file too large to map needs a 64 bit m")
+	}
+
+	// The file for a filter of 2^62 bits either cannot be sized or cannot
+	// be mapped, which must not leave a partially initialized file behind.
+	path := filepath.Join(dir, "bloom.db")
+	_, err = CreatePersistentBloomFilter(path, ^uint(0)>>2, 4)
+	require.Error(t, err)
+	_, err = os.Stat(path)
+	require.True(t, os.IsNotExist(err), "unexpected error: %v", err)
+
+	m, k := EstimateFalsePositiveRate(1000, 0.01)
+	f, err := CreatePersistentBloomFilter(path, m, k)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+}
+
+func TestPersistentBloomFilterFromWriteTo(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bloom")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	b := NewBloomFilter(1000, 4)
+	b.Add([]byte("Bess"))
+	path := writeTestFile(t, dir, b)
+
+	f, err := OpenPersistentBloomFilter(path, OpenOptions{})
+	require.NoError(t, err)
+	require.True(t, f.Test([]byte("Bess")))
+	require.False(t, f.Test([]byte("Jane")))
+	require.NoError(t, f.Close())
+
+	_, err = CreatePersistentBloomFilter(filepath.Join(dir, "zero"), 1000, 0)
+	require.Equal(t, ErrZeroK, err)
+}
@@ -0,0 +1,169 @@
+package bloom
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+var (
+	// ErrInvalidFalsePositiveRate is returned when planning a bloom filter
+	// with a false positive rate outside of (0, 1).
+	ErrInvalidFalsePositiveRate = errors.New("bloom: false positive rate must be in (0, 1)")
+	// ErrZeroN is returned when planning a bloom filter for zero values.
+	ErrZeroN = errors.New("bloom: n must be greater than zero")
+	// ErrTooSmall is returned when planning a bloom filter with a size
+	// too small to hold any values at the false positive rate.
+	ErrTooSmall = errors.New("bloom: size is too small")
+	// ErrTooLarge is returned when planning a bloom filter with more
+	// elements than can be represented.
+	ErrTooLarge = errors.New("bloom: size is too large")
+)
+
+// Plan is the parameters of a bloom filter.
+type Plan struct {
+	// N is the number of values the filter holds.
+	N uint
+	// M is the m elements represented.
+	M uint
+	// K is the k hashes used.
+	K uint
+	// FalsePositiveRate is the expected false positive rate once N values
+	// have been added to a filter with the rounded M and K.
+	FalsePositiveRate float64
+	// SizeBytes is the size of the filter when written with WriteTo.
+	SizeBytes uint64
+	// IdealM is the optimal m before it was rounded to M.
+	IdealM float64
+	// IdealK is the optimal k before it was rounded to K.
+	IdealK float64
+}
+
+// PlanForFalsePositiveRate plans a bloom filter holding n values with a
+// false positive rate of at most about p, rounding m up and k to the
+// nearest integer. It supersedes EstimateFalsePositiveRate.
+func PlanForFalsePositiveRate(n uint, p float64) (Plan, error) {
+	if n == 0 {
+		return Plan{}, ErrZeroN
+	}
+	if err := validateFalsePositiveRate(p); err != nil {
+		return Plan{}, err
+	}
+	idealM := -float64(n) * math.Log(p) / (math.Ln2 * math.Ln2)
+	// float64(maxUint) rounds up to 2^64 or 2^32, which itself does not
+	// fit in a uint.
+	if math.Ceil(idealM) >= float64(maxUint) {
+		return Plan{}, fmt.Errorf("%w: n=%d, p=%v needs m=%v", ErrTooLarge, n, p, idealM)
+	}
+	m := uint(math.Ceil(idealM))
+	plan := newPlan(n, m)
+	plan.IdealM = idealM
+	return plan, nil
+}
+
+// PlanForMemory plans the bloom filter with the lowest false positive rate
+// holding n values whose size when written with WriteTo, header included,
+// fits in a memory budget in bytes.
+func PlanForMemory(n uint, budget uint64) (Plan, error) {
+	if n == 0 {
+		return Plan{}, ErrZeroN
+	}
+	if budget < headerLen+bitSetBytesLen(1) {
+		return Plan{}, fmt.Errorf("%w: %d bytes", ErrTooSmall, budget)
+	}
+	// The bits of a filter of m bits are stored as m/64+1 64 bit words,
+	// budgets for more words than a uint m can represent use the largest m.
+	payload := budget - headerLen
+	idealM := float64(payload) * 8
+	m := maxUint
+	if words := payload / 8; words <= uint64(maxUint)/64 {
+		m = uint(words*64 - 1)
+	}
+	plan := newPlan(n, m)
+	plan.IdealM = idealM
+	return plan, nil
+}
+
+// PlanForCapacity plans the bloom filter of m elements that can hold the
+// most values with a false positive rate of at most p.
+func PlanForCapacity(m uint, p float64) (Plan, error) {
+	if m == 0 {
+		return Plan{}, ErrZeroM
+	}
+	if err := validateFalsePositiveRate(p); err != nil {
+		return Plan{}, err
+	}
+	idealK := -math.Log2(p)
+	k := roundK(idealK)
+	// Solve (1 - e^(-kn/m))^k = p for n.
+	idealN := -float64(m) / float64(k) * math.Log1p(-math.Pow(p, 1/float64(k)))
+	n := uint(math.Floor(idealN))
+	if n == 0 {
+		return Plan{}, fmt.Errorf("%w: m=%d, p=%v", ErrTooSmall, m, p)
+	}
+	return Plan{
+		N:                 n,
+		M:                 m,
+		K:                 k,
+		FalsePositiveRate: falsePositiveRate(m, k, n),
+		SizeBytes:         headerLen + bitSetBytesLen(uint64(m)),
+		IdealM:            float64(m),
+		IdealK:            idealK,
+	}, nil
+}
+
+// OptimalK returns the number of hashes that minimizes the false positive
+// rate of a bloom filter of m elements holding n values.
+func OptimalK(m, n uint) (uint, error) {
+	if m == 0 {
+		return 0, ErrZeroM
+	}
+	if n == 0 {
+		return 0, ErrZeroN
+	}
+	return roundK(idealK(m, n)), nil
+}
+
+// maxUint is the largest uint.
+const maxUint = ^uint(0)
+
+func newPlan(n, m uint) Plan {
+	ideal := idealK(m, n)
+	k := roundK(ideal)
+	return Plan{
+		N:                 n,
+		M:                 m,
+		K:                 k,
+		FalsePositiveRate: falsePositiveRate(m, k, n),
+		SizeBytes:         headerLen + bitSetBytesLen(uint64(m)),
+		IdealK:            ideal,
+	}
+}
+
+func idealK(m, n uint) float64 {
+	return float64(m) / float64(n) * math.Ln2
+}
+
+func roundK(k float64) uint {
+	rounded := math.Round(k)
+	if rounded < 1 {
+		return 1
+	}
+	if rounded > MaxK {
+		return MaxK
+	}
+	return uint(rounded)
+}
+
+// falsePositiveRate returns the expected false positive rate of a bloom
+// filter of m elements and k hashes holding n values.
+func falsePositiveRate(m, k, n uint) float64 {
+	return math.Pow(-math.Expm1(-float64(k)*float64(n)/float64(m)), float64(k))
+}
+
+func validateFalsePositiveRate(p float64) error {
+	if !(p > 0 && p < 1) {
+		return fmt.Errorf("%w: %v", ErrInvalidFalsePositiveRate, p)
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package bloom
+
+import (
+	"errors"
+	"io/ioutil"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPlanForFalsePositiveRate(t *testing.T) {
+	plan, err := PlanForFalsePositiveRate(1000, 0.01)
+	require.NoError(t, err)
+	require.Equal(t, uint(1000), plan.N)
+	require.Equal(t, uint(9586), plan.M)
+	require.Equal(t, uint(7), plan.K)
+	require.InDelta(t, 9585.06, plan.IdealM, 0.01)
+	require.InDelta(t, 6.64, plan.IdealK, 0.01)
+	require.InEpsilon(t, 0.01, plan.FalsePositiveRate, 0.01)
+	require.Equal(t, uint64(headerLen+8*150), plan.SizeBytes)
+
+	// The plan matches the size of a written filter.
+	f := NewBloomFilter(plan.M, plan.K)
+	n, err := f.WriteTo(ioutil.Discard)
+	require.NoError(t, err)
+	require.Equal(t, int64(plan.SizeBytes), n)
+}
+
+func TestPlanForMemory(t *testing.T) {
+	plan, err := PlanForMemory(1000, 1240)
+	require.NoError(t, err)
+	require.Equal(t, uint(1000), plan.N)
+	require.Equal(t, uint(9599), plan.M)
+	require.Equal(t, uint(7), plan.K)
+	require.Equal(t, uint64(1200), bitSetBytesLen(uint64(plan.M)))
+	require.Equal(t, uint64(1240), plan.SizeBytes)
+	require.True(t, plan.FalsePositiveRate < 0.01)
+
+	// The plan fits the budget once written.
+	f := NewBloomFilter(plan.M, plan.K)
+	n, err := f.WriteTo(ioutil.Discard)
+	require.NoError(t, err)
+	require.Equal(t, int64(plan.SizeBytes), n)
+
+	plan, err = PlanForMemory(1000, headerLen+8)
+	require.NoError(t, err)
+	require.Equal(t, uint(63), plan.M)
+	_, err = PlanForMemory(1000, headerLen+7)
+	require.True(t, errors.Is(err, ErrTooSmall), "unexpected error: %v", err)
+
+	// Budgets for more bits than a uint can represent do not wrap around.
+	plan, err = PlanForMemory(1000, math.MaxUint64)
+	require.NoError(t, err)
+	require.Equal(t, ^uint(0), plan.M)
+}
+
+func TestPlanForCapacity(t *testing.T) {
+	plan, err := PlanForCapacity(9586, 0.01)
+	require.NoError(t, err)
+	require.Equal(t, uint(9586), plan.M)
+	require.Equal(t, uint(7), plan.K)
+	require.InDelta(t, 1000, plan.N, 10)
+	require.True(t, plan.FalsePositiveRate <= 0.01)
+	require.True(t, falsePositiveRate(plan.M, plan.K, plan.N+1) > 0.01)
+
+	_, err = PlanForCapacity(1, 0.0001)
+	require.True(t, errors.Is(err, ErrTooSmall), "unexpected error: %v", err)
+}
+
+func TestOptimalK(t *testing.T) {
+	k, err := OptimalK(9586, 1000)
+	require.NoError(t, err)
+	require.Equal(t, uint(7), k)
+
+	k, err = OptimalK(1, 1000)
+	require.NoError(t, err)
+	require.Equal(t, uint(1), k)
+
+	_, err = OptimalK(0, 1000)
+	require.Equal(t, ErrZeroM, err)
+	_, err = OptimalK(1000, 0)
+	require.Equal(t, ErrZeroN, err)
+}
+
+func TestPlanErrors(t *testing.T) {
+	for _, p := range []float64{0, 1, -0.1, 1.5, math.NaN(), math.Inf(1)} {
+		_, err := PlanForFalsePositiveRate(1000, p)
+		require.True(t, errors.Is(err, ErrInvalidFalsePositiveRate), "unexpected error: %v", err)
+		_, err = PlanForCapacity(1000, p)
+		require.True(t, errors.Is(err, ErrInvalidFalsePositiveRate), "unexpected error: %v", err)
+	}
+
+	_, err := PlanForFalsePositiveRate(0, 0.01)
+	require.Equal(t, ErrZeroN, err)
+	_, err = PlanForFalsePositiveRate(^uint(0), 1e-300)
+	require.True(t, errors.Is(err, ErrTooLarge), "unexpected error: %v", err)
+	_, err = PlanForMemory(0, 1000)
+	require.Equal(t, ErrZeroN, err)
+	_, err = PlanForCapacity(0, 0.01)
+	require.Equal(t, ErrZeroM, err)
+}
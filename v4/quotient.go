@@ -0,0 +1,416 @@
+package bloom
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+const (
+	defaultRemainderBits = 16
+	// maxRemainderBits is the widest remainder that fits a packedArray
+	// slot along with the three metadata bits.
+	maxRemainderBits = maxPackedWidth - 3
+	// maxQuotientBits bounds the number of slots of a quotient filter.
+	maxQuotientBits = 48
+	// maxQuotientLoadFactor is the fraction of slots that can be used,
+	// clusters grow long and slow down operations as a quotient filter
+	// fills.
+	maxQuotientLoadFactor = 0.9
+
+	// The metadata bits of a slot, a slot is empty if none are set.
+	slotOccupied     = 1 << 0
+	slotContinuation = 1 << 1
+	slotShifted      = 1 << 2
+	slotMetadata     = slotOccupied | slotContinuation | slotShifted
+)
+
+var (
+	// ErrQuotientFilterFull is returned when adding a value to a quotient
+	// filter that is full.
+	ErrQuotientFilterFull = errors.New("bloom: quotient filter is full")
+	// ErrInvalidRemainderBits is returned when creating a quotient filter
+	// with remainders of a width outside of [1, 29] bits.
+	ErrInvalidRemainderBits = errors.New("bloom: remainder bits must be in [1, 29]")
+	// ErrFingerprintTooWide is returned when creating a quotient filter
+	// whose fingerprints, its quotient and remainder bits, are wider than
+	// the 64 bits they are taken from.
+	ErrFingerprintTooWide = errors.New("bloom: quotient and remainder bits must not exceed 64")
+)
+
+// QuotientOptions are options for creating a quotient filter.
+type QuotientOptions struct {
+	// RemainderBits is the width in bits of the remainder stored for each
+	// value, from 1 to 29, or zero for 16 bit remainders.
+	RemainderBits uint
+	// Hasher is the hasher used, or nil for Murmur3Hasher.
+	Hasher Hasher
+}
+
+// QuotientFilter is a quotient filter set membership that values can be
+// removed from, and that can be resized and merged without the original
+// values, as described by Bender et al. in "Don't Thrash: How to Cache
+// Your Hash on Flash". The first q+r bits of the digest of a value are its
+// fingerprint, the first q bits are the quotient and select a slot of 2^q
+// slots, the remaining r bits are the remainder stored near that slot.
+// The false positive rate is about the load factor times 2^-r.
+// Resize doubles the number of slots by moving a bit from the remainder
+// to the quotient, so each resize doubles the false positive rate.
+// Only values that were added should be removed, removing any other value
+// may remove the fingerprint of a different value that shares it.
+// It cannot be concurrently read or written to, a sync.Mutex must be used
+// to guard read/write access if desired.
+type QuotientFilter struct {
+	q      uint
+	r      uint
+	slots  packedArray
+	count  uint64
+	hasher Hasher
+}
+
+// NewQuotientFilter creates a new quotient filter that can hold capacity
+// values with 16 bit remainders. It is not concurrent read or write safe.
+func NewQuotientFilter(capacity uint) *QuotientFilter {
+	b, _ := NewQuotientFilterWithOptions(capacity, QuotientOptions{})
+	return b
+}
+
+// NewQuotientFilterWithOptions creates a new quotient filter that can hold
+// capacity values. It is not concurrent read or write safe.
+func NewQuotientFilterWithOptions(
+	capacity uint,
+	opts QuotientOptions,
+) (*QuotientFilter, error) {
+	r := opts.RemainderBits
+	if r == 0 {
+		r = defaultRemainderBits
+	}
+	if r > maxRemainderBits {
+		return nil, fmt.Errorf("%w: %d", ErrInvalidRemainderBits, r)
+	}
+	hasher := opts.Hasher
+	if hasher == nil {
+		hasher = Murmur3Hasher
+	}
+	q := uint(1)
+	for float64(uint64(1)<<q)*maxQuotientLoadFactor < float64(capacity) {
+		q++
+	}
+	if q > maxQuotientBits {
+		return nil, fmt.Errorf("%w: capacity %d", ErrQuotientFilterFull, capacity)
+	}
+	// Resizing and merging keep q+r, so it only needs checking here.
+	if q+r > 64 {
+		return nil, fmt.Errorf("%w: %d quotient and %d remainder bits",
+			ErrFingerprintTooWide, q, r)
+	}
+	return newQuotientFilter(q, r, hasher), nil
+}
+
+func newQuotientFilter(q, r uint, hasher Hasher) *QuotientFilter {
+	return &QuotientFilter{
+		q:      q,
+		r:      r,
+		slots:  newPackedArray(uint64(1)<<q, r+3),
+		hasher: hasher,
+	}
+}
+
+// Add value to the set, it returns ErrQuotientFilterFull without adding
+// the value if the filter is full, after which it can be resized.
+func (b *QuotientFilter) Add(value []byte) error {
+	return b.AddDigest(Digest(b.hasher.Sum(value)))
+}
+
+// AddDigest adds the value with a digest to the set, the digest must have
+// been computed with the hasher of the filter. It returns
+// ErrQuotientFilterFull without adding the value if the filter is full.
+func (b *QuotientFilter) AddDigest(h Digest) error {
+	if b.full(1) {
+		return ErrQuotientFilterFull
+	}
+	b.insert(b.fingerprint(h))
+	return nil
+}
+
+// Remove value from the set, returning whether the value may have been in
+// the set.
+func (b *QuotientFilter) Remove(value []byte) bool {
+	return b.RemoveDigest(Digest(b.hasher.Sum(value)))
+}
+
+// RemoveDigest removes the value with a digest from the set, the digest
+// must have been computed with the hasher of the filter. It returns
+// whether the value may have been in the set.
+func (b *QuotientFilter) RemoveDigest(h Digest) bool {
+	fq, fr := b.split(b.fingerprint(h))
+	if b.slot(fq)&slotOccupied == 0 {
+		return false
+	}
+	start, entries := b.decodeCluster(fq)
+	for i, e := range entries {
+		if e.quotient%b.size() == fq && e.remainder == fr {
+			b.encode(start, len(entries), append(entries[:i:i], entries[i+1:]...))
+			b.count--
+			return true
+		}
+	}
+	return false
+}
+
+// Test if value is in the set.
+func (b *QuotientFilter) Test(value []byte) bool {
+	return b.TestDigest(Digest(b.hasher.Sum(value)))
+}
+
+// TestDigest tests if the value with a digest is in the set, the digest
+// must have been computed with the hasher of the filter.
+func (b *QuotientFilter) TestDigest(h Digest) bool {
+	fq, fr := b.split(b.fingerprint(h))
+	if b.slot(fq)&slotOccupied == 0 {
+		return false
+	}
+	// Find the start of the cluster, then walk forward to the run of fq
+	// counting runs as the occupied slots they belong to are passed.
+	c := fq
+	for b.slot(c)&slotShifted != 0 {
+		c = b.prev(c)
+	}
+	s := c
+	for c != fq {
+		for s = b.next(s); b.slot(s)&slotContinuation != 0; s = b.next(s) {
+		}
+		for c = b.next(c); b.slot(c)&slotOccupied == 0; c = b.next(c) {
+		}
+	}
+	// Runs are sorted by remainder.
+	for {
+		rem := b.remainder(s)
+		if rem == fr {
+			return true
+		}
+		if rem > fr {
+			return false
+		}
+		s = b.next(s)
+		if b.slot(s)&slotContinuation == 0 {
+			return false
+		}
+	}
+}
+
+// Resize doubles the number of slots of the filter by moving a bit of the
+// fingerprints from the remainder to the quotient, which doubles the false
+// positive rate. It returns ErrQuotientFilterFull if there is only one
+// remainder bit left.
+func (b *QuotientFilter) Resize() error {
+	if b.r <= 1 || b.q >= maxQuotientBits {
+		return fmt.Errorf("%w: cannot resize %d quotient and %d remainder bits",
+			ErrQuotientFilterFull, b.q, b.r)
+	}
+	resized := newQuotientFilter(b.q+1, b.r-1, b.hasher)
+	b.fingerprints(func(fp uint64) {
+		resized.insert(fp)
+	})
+	*b = *resized
+	return nil
+}
+
+// Merge adds the values of other to the set from their fingerprints,
+// resizing the filter as needed to hold them. Both filters must use the
+// same hasher and the same number of fingerprint bits, the sum of their
+// quotient and remainder bits.
+func (b *QuotientFilter) Merge(other *QuotientFilter) error {
+	if b.hasher.Scheme() != other.hasher.Scheme() {
+		return &IncompatibleFiltersError{
+			Param:  "hash scheme",
+			Values: [2]uint64{uint64(b.hasher.Scheme()), uint64(other.hasher.Scheme())},
+		}
+	}
+	if b.q+b.r != other.q+other.r {
+		return &IncompatibleFiltersError{
+			Param:  "fingerprint bits",
+			Values: [2]uint64{uint64(b.q + b.r), uint64(other.q + other.r)},
+		}
+	}
+	for b.full(other.count) {
+		if err := b.Resize(); err != nil {
+			return err
+		}
+	}
+	// Collect the fingerprints first so that a filter can be merged with
+	// itself.
+	fps := make([]uint64, 0, other.count)
+	other.fingerprints(func(fp uint64) {
+		fps = append(fps, fp)
+	})
+	for _, fp := range fps {
+		b.insert(fp)
+	}
+	return nil
+}
+
+// Count returns the number of values in the set.
+func (b *QuotientFilter) Count() uint64 {
+	return b.count
+}
+
+// Capacity returns the number of values the filter can hold before it is
+// full.
+func (b *QuotientFilter) Capacity() uint64 {
+	return uint64(float64(b.size()) * maxQuotientLoadFactor)
+}
+
+// QuotientBits returns the number of bits of the fingerprints used as the
+// quotient.
+func (b *QuotientFilter) QuotientBits() uint {
+	return b.q
+}
+
+// RemainderBits returns the number of bits of the fingerprints stored as
+// the remainder.
+func (b *QuotientFilter) RemainderBits() uint {
+	return b.r
+}
+
+// Hasher returns the hasher used.
+func (b *QuotientFilter) Hasher() Hasher {
+	return b.hasher
+}
+
+func (b *QuotientFilter) full(n uint64) bool {
+	return b.count+n > b.Capacity()
+}
+
+func (b *QuotientFilter) size() uint64 {
+	return uint64(1) << b.q
+}
+
+func (b *QuotientFilter) fingerprint(h Digest) uint64 {
+	return h[0] >> (64 - b.q - b.r)
+}
+
+func (b *QuotientFilter) split(fp uint64) (uint64, uint64) {
+	return fp >> b.r, fp & (1<<b.r - 1)
+}
+
+func (b *QuotientFilter) slot(i uint64) uint64 {
+	return uint64(b.slots.get(i))
+}
+
+func (b *QuotientFilter) remainder(i uint64) uint64 {
+	return b.slot(i) >> 3
+}
+
+func (b *QuotientFilter) next(i uint64) uint64 {
+	return (i + 1) & (b.size() - 1)
+}
+
+func (b *QuotientFilter) prev(i uint64) uint64 {
+	return (i - 1) & (b.size() - 1)
+}
+
+// quotientEntry is a fingerprint stored in a quotient filter, the quotient
+// is relative to the start of the cluster it was decoded from and may
+// exceed the number of slots when the cluster wraps around.
+type quotientEntry struct {
+	quotient  uint64
+	remainder uint64
+}
+
+func (b *QuotientFilter) insert(fp uint64) {
+	fq, fr := b.split(fp)
+	start, entries := b.decodeCluster(fq)
+	if fq < start {
+		fq += b.size()
+	}
+	i := sort.Search(len(entries), func(i int) bool {
+		e := entries[i]
+		return e.quotient > fq || e.quotient == fq && e.remainder > fr
+	})
+	updated := make([]quotientEntry, 0, len(entries)+1)
+	updated = append(updated, entries[:i]...)
+	updated = append(updated, quotientEntry{quotient: fq, remainder: fr})
+	updated = append(updated, entries[i:]...)
+	b.encode(start, len(entries), updated)
+	b.count++
+}
+
+// decodeCluster returns the start of the cluster containing slot i and the
+// fingerprints stored from there up to the next empty slot, which may
+// span several clusters, in the order they are stored.
+func (b *QuotientFilter) decodeCluster(i uint64) (uint64, []quotientEntry) {
+	start := i
+	for b.slot(start)&slotShifted != 0 {
+		start = b.prev(start)
+	}
+	var (
+		entries  []quotientEntry
+		pending  []uint64
+		quotient uint64
+	)
+	for s := start; ; s++ {
+		slot := b.slot(s & (b.size() - 1))
+		if slot&slotMetadata == 0 {
+			return start, entries
+		}
+		if slot&slotOccupied != 0 {
+			pending = append(pending, s)
+		}
+		if slot&slotContinuation == 0 {
+			quotient, pending = pending[0], pending[1:]
+		}
+		entries = append(entries, quotientEntry{quotient: quotient, remainder: slot >> 3})
+	}
+}
+
+// encode replaces the n slots from start with fingerprints sorted by their
+// quotient relative to start and then by remainder.
+func (b *QuotientFilter) encode(start uint64, n int, entries []quotientEntry) {
+	mask := b.size() - 1
+	for i := uint64(0); i < uint64(n); i++ {
+		b.slots.set((start+i)&mask, 0)
+	}
+	pos := start
+	for i, e := range entries {
+		var slot uint64
+		if i > 0 && entries[i-1].quotient == e.quotient {
+			slot |= slotContinuation
+		} else if pos < e.quotient {
+			pos = e.quotient
+		}
+		if pos != e.quotient {
+			slot |= slotShifted
+		}
+		occupied := b.slot(pos&mask) & slotOccupied
+		b.slots.set(pos&mask, uint32(slot|occupied|e.remainder<<3))
+		pos++
+	}
+	for _, e := range entries {
+		b.slots.set(e.quotient&mask, uint32(b.slot(e.quotient&mask)|slotOccupied))
+	}
+}
+
+// fingerprints calls fn with each fingerprint stored in the filter in
+// increasing order of quotient, starting from the first empty slot.
+func (b *QuotientFilter) fingerprints(fn func(fp uint64)) {
+	if b.count == 0 {
+		return
+	}
+	first := uint64(0)
+	for b.slot(first)&slotMetadata != 0 {
+		first++
+	}
+	for i := uint64(0); i < b.size(); {
+		s := (first + i) & (b.size() - 1)
+		if b.slot(s)&slotMetadata == 0 {
+			i++
+			continue
+		}
+		_, entries := b.decodeCluster(s)
+		for _, e := range entries {
+			fn((e.quotient&(b.size()-1))<<b.r | e.remainder)
+		}
+		i += uint64(len(entries))
+	}
+}
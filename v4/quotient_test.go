@@ -0,0 +1,181 @@
+package bloom
+
+import (
+	"errors"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// storedFingerprints returns the sorted fingerprints stored in a quotient
+// filter.
+func storedFingerprints(f *QuotientFilter) []uint64 {
+	var fps []uint64
+	f.fingerprints(func(fp uint64) {
+		fps = append(fps, fp)
+	})
+	sort.Slice(fps, func(i, j int) bool { return fps[i] < fps[j] })
+	return fps
+}
+
+func TestQuotientFilter(t *testing.T) {
+	f := NewQuotientFilter(1000)
+	n1 := []byte("Bess")
+	n2 := []byte("Jane")
+	n3 := []byte("Emma")
+	require.NoError(t, f.Add(n1))
+	require.NoError(t, f.Add(n3))
+
+	require.Equal(t, uint(11), f.QuotientBits())
+	require.Equal(t, uint(16), f.RemainderBits())
+	require.Equal(t, uint64(2), f.Count())
+	require.True(t, f.Test(n1))
+	require.False(t, f.Test(n2))
+	require.True(t, f.Test(n3))
+
+	require.True(t, f.Remove(n1))
+	require.False(t, f.Test(n1))
+	require.True(t, f.Test(n3))
+	require.False(t, f.Remove(n2))
+	require.Equal(t, uint64(1), f.Count())
+}
+
+func TestQuotientFilterRandomOps(t *testing.T) {
+	// A small filter with short remainders so that clusters are long, wrap
+	// around the end of the slots and fingerprints collide.
+	f, err := NewQuotientFilterWithOptions(200, QuotientOptions{RemainderBits: 2})
+	require.NoError(t, err)
+	rng := rand.New(rand.NewSource(1))
+
+	var (
+		added    [][]byte
+		expected = make(map[uint64]int)
+		buff     [8]byte
+	)
+	for i := 0; i < 20000; i++ {
+		if len(added) > 0 && (rng.Intn(2) == 0 || f.full(1)) {
+			j := rng.Intn(len(added))
+			value := added[j]
+			added[j] = added[len(added)-1]
+			added = added[:len(added)-1]
+			require.True(t, f.Remove(value))
+			expected[f.fingerprint(Hash(value))]--
+		} else {
+			endianness.PutUint64(buff[:], rng.Uint64())
+			value := append([]byte(nil), buff[:]...)
+			require.NoError(t, f.Add(value))
+			added = append(added, value)
+			expected[f.fingerprint(Hash(value))]++
+		}
+
+		if i%100 == 0 {
+			var fps []uint64
+			for fp, n := range expected {
+				for j := 0; j < n; j++ {
+					fps = append(fps, fp)
+				}
+			}
+			sort.Slice(fps, func(i, j int) bool { return fps[i] < fps[j] })
+			require.Equal(t, fps, storedFingerprints(f))
+			for _, value := range added {
+				require.True(t, f.Test(value))
+			}
+		}
+	}
+	require.Equal(t, uint64(len(added)), f.Count())
+}
+
+func TestQuotientFilterFull(t *testing.T) {
+	f := NewQuotientFilter(100)
+	var buff [8]byte
+	for i := uint64(0); i < f.Capacity(); i++ {
+		endianness.PutUint64(buff[:], i)
+		require.NoError(t, f.Add(buff[:]))
+	}
+	endianness.PutUint64(buff[:], f.Capacity())
+	require.Equal(t, ErrQuotientFilterFull, f.Add(buff[:]))
+
+	require.NoError(t, f.Resize())
+	require.NoError(t, f.Add(buff[:]))
+}
+
+func TestQuotientFilterResize(t *testing.T) {
+	f, err := NewQuotientFilterWithOptions(1000, QuotientOptions{RemainderBits: 4})
+	require.NoError(t, err)
+	var buff [8]byte
+	for i := 0; i < 1000; i++ {
+		endianness.PutUint64(buff[:], uint64(i))
+		require.NoError(t, f.Add(buff[:]))
+	}
+	before := storedFingerprints(f)
+
+	for r := uint(3); r >= 1; r-- {
+		require.NoError(t, f.Resize())
+		require.Equal(t, uint(11+4-r), f.QuotientBits())
+		require.Equal(t, r, f.RemainderBits())
+		require.Equal(t, uint64(1000), f.Count())
+		require.Equal(t, before, storedFingerprints(f))
+		for i := 0; i < 1000; i++ {
+			endianness.PutUint64(buff[:], uint64(i))
+			require.True(t, f.Test(buff[:]))
+		}
+	}
+	require.True(t, errors.Is(f.Resize(), ErrQuotientFilterFull))
+}
+
+func TestQuotientFilterMerge(t *testing.T) {
+	a := NewQuotientFilter(1000)
+	b, err := NewQuotientFilterWithOptions(100, QuotientOptions{RemainderBits: 20})
+	require.NoError(t, err)
+	var buff [8]byte
+	for i := 0; i < 1800; i++ {
+		endianness.PutUint64(buff[:], uint64(i))
+		require.NoError(t, a.Add(buff[:]))
+	}
+	for i := 1800; i < 1900; i++ {
+		endianness.PutUint64(buff[:], uint64(i))
+		require.NoError(t, b.Add(buff[:]))
+	}
+
+	// The filters differ in quotient and remainder bits but have 27 bit
+	// fingerprints, a is resized to hold the values of both.
+	require.NoError(t, a.Merge(b))
+	require.Equal(t, uint64(1900), a.Count())
+	require.Equal(t, uint(12), a.QuotientBits())
+	for i := 0; i < 1900; i++ {
+		endianness.PutUint64(buff[:], uint64(i))
+		require.True(t, a.Test(buff[:]))
+	}
+
+	require.NoError(t, b.Merge(b))
+	require.Equal(t, uint64(200), b.Count())
+
+	c := NewQuotientFilter(100)
+	var incompatible *IncompatibleFiltersError
+	require.True(t, errors.As(a.Merge(c), &incompatible))
+	require.Equal(t, "fingerprint bits", incompatible.Param)
+
+	d, err := NewQuotientFilterWithOptions(1000, QuotientOptions{Hasher: XXHash64Hasher})
+	require.NoError(t, err)
+	require.True(t, errors.As(a.Merge(d), &incompatible))
+	require.Equal(t, "hash scheme", incompatible.Param)
+}
+
+func TestQuotientFilterOptions(t *testing.T) {
+	_, err := NewQuotientFilterWithOptions(100, QuotientOptions{RemainderBits: 30})
+	require.True(t, errors.Is(err, ErrInvalidRemainderBits))
+
+	if ^uint(0)>>32 == 0 {
+		t.Skip("a capacity needing more than 35 quotient bits needs a 64 bit uint")
+	}
+	// 2^40 values need 41 quotient bits, with 24 bit remainders the
+	// fingerprints would be 65 bits.
+	capacity := ^uint(0) >> 24
+	_, err = NewQuotientFilterWithOptions(capacity, QuotientOptions{RemainderBits: 24})
+	require.True(t, errors.Is(err, ErrFingerprintTooWide), "unexpected error: %v", err)
+	f, err := NewQuotientFilterWithOptions(1000, QuotientOptions{RemainderBits: 29})
+	require.NoError(t, err)
+	require.Equal(t, uint(40), f.QuotientBits()+f.RemainderBits())
+}
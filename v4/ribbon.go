@@ -0,0 +1,391 @@
+package bloom
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/bits"
+)
+
+const (
+	// ribbonHeaderMagic identifies a serialized ribbon filter, it is "M3RF"
+	// when read as little endian bytes.
+	ribbonHeaderMagic uint32 = 0x4652334d
+	// ribbonHeaderLen is the length in bytes of a serialized ribbon filter
+	// header.
+	ribbonHeaderLen = 40
+	// ribbonChecksumOffset is the offset of the checksum in a serialized
+	// ribbon filter header, the bytes before it and the solution are
+	// covered by it.
+	ribbonChecksumOffset = 32
+
+	// ribbonWidth is the number of consecutive slots each key's equation
+	// spans, and the number of slots in a block of the solution.
+	ribbonWidth = 64
+
+	defaultRibbonResultBits = 8
+	maxRibbonResultBits     = 32
+	defaultRibbonOverhead   = 0.1
+)
+
+// ErrInvalidResultBits is returned when creating a ribbon filter with a
+// number of result bits outside of [1, 32].
+var ErrInvalidResultBits = errors.New("bloom: result bits must be in [1, 32]")
+
+// ErrInvalidOverhead is returned when creating a ribbon filter with a
+// negative or non finite space overhead.
+var ErrInvalidOverhead = errors.New("bloom: overhead must be a finite non negative number")
+
+// KeyIterator iterates over keys.
+type KeyIterator interface {
+	// Next advances to the next key, returning false when there are no
+	// more keys or an error occurred.
+	Next() bool
+
+	// Current returns the current key, it is only valid until Next is
+	// called again.
+	Current() []byte
+
+	// Err returns any error that occurred while iterating.
+	Err() error
+}
+
+// RibbonOptions are options for building a ribbon filter.
+type RibbonOptions struct {
+	// ResultBits is the number of bits stored per slot, from 1 to 32, or
+	// zero for 8 bits. The false positive rate is about 2^-ResultBits.
+	ResultBits uint
+	// Overhead is the number of slots in excess of the number of keys as a
+	// fraction of the number of keys, or zero for 0.1. Larger overheads
+	// use more space for a false positive rate closer to 2^-ResultBits.
+	Overhead float64
+	// Hasher is the hasher used, or nil for Murmur3Hasher.
+	Hasher Hasher
+}
+
+func (o RibbonOptions) withDefaults() (RibbonOptions, error) {
+	if o.ResultBits == 0 {
+		o.ResultBits = defaultRibbonResultBits
+	}
+	if o.ResultBits > maxRibbonResultBits {
+		return RibbonOptions{}, fmt.Errorf("%w: %d", ErrInvalidResultBits, o.ResultBits)
+	}
+	if o.Overhead == 0 {
+		o.Overhead = defaultRibbonOverhead
+	}
+	if o.Overhead < 0 || math.IsInf(o.Overhead, 0) || math.IsNaN(o.Overhead) {
+		return RibbonOptions{}, fmt.Errorf("%w: %v", ErrInvalidOverhead, o.Overhead)
+	}
+	if o.Hasher == nil {
+		o.Hasher = Murmur3Hasher
+	}
+	return o, nil
+}
+
+// ribbonTable is the solution of a ribbon filter, stored in blocks of 64
+// slots. Each block is ResultBits 64 bit words, word k of a block holds
+// bit k of the result of each of the slots of the block.
+type ribbonTable struct {
+	count      uint64
+	blocks     uint64
+	resultBits uint64
+	data       []byte
+	hasher     Hasher
+}
+
+// locate returns the first slot of the equation of a digest and the
+// coefficients of the equation, bit j is set if slot start+j is used.
+func (t *ribbonTable) locate(h Digest) (uint64, uint64) {
+	start, _ := bits.Mul64(h[0], t.blocks*ribbonWidth-ribbonWidth+1)
+	return start, h[1] | 1
+}
+
+func (t *ribbonTable) word(block, bit uint64) uint64 {
+	return binary.LittleEndian.Uint64(t.data[8*(block*t.resultBits+bit):])
+}
+
+func (t *ribbonTable) test(h Digest) bool {
+	start, coeff := t.locate(h)
+	block, shift := start/ribbonWidth, start%ribbonWidth
+	for k := uint64(0); k < t.resultBits; k++ {
+		v := t.word(block, k) >> shift
+		if shift != 0 {
+			v |= t.word(block+1, k) << (ribbonWidth - shift)
+		}
+		if bits.OnesCount64(v&coeff)&1 != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// RibbonFilter is an immutable homogeneous ribbon filter set membership
+// built from a complete set of keys, as described by Dillinger and Walzer
+// in "Ribbon filter: practically smaller than Bloom and Xor". Each key is
+// an equation over the results of 64 consecutive slots that is solved
+// when the filter is built, a value is in the set if the results of its
+// slots satisfy its equation. As every equation is homogeneous the system
+// can always be solved, so building never fails and duplicate keys are
+// harmless. With r result bits and an Overhead of 0.1 the false positive
+// rate is within about 10% of 2^-r using 1.1*r bits per key, BloomFilter
+// needs about 1.44*r bits per key for the same false positive rate. The
+// false positive rate grows quickly with an Overhead below about 0.08 for
+// sets of a million keys, smaller sets tolerate less Overhead.
+// It can be concurrently read from by any number of readers.
+type RibbonFilter struct {
+	ribbonTable
+}
+
+// BuildRibbonFilter builds a ribbon filter from a set of keys.
+func BuildRibbonFilter(keys [][]byte, opts RibbonOptions) (*RibbonFilter, error) {
+	opts, err := opts.withDefaults()
+	if err != nil {
+		return nil, err
+	}
+	digests := make([]Digest, len(keys))
+	for i, key := range keys {
+		digests[i] = Digest(opts.Hasher.Sum(key))
+	}
+	return buildRibbonFilter(digests, opts), nil
+}
+
+// BuildRibbonFilterFromIterator builds a ribbon filter from the keys of an
+// iterator, the keys are hashed as they are iterated so they need not
+// remain valid.
+func BuildRibbonFilterFromIterator(
+	iter KeyIterator,
+	opts RibbonOptions,
+) (*RibbonFilter, error) {
+	opts, err := opts.withDefaults()
+	if err != nil {
+		return nil, err
+	}
+	var digests []Digest
+	for iter.Next() {
+		digests = append(digests, Digest(opts.Hasher.Sum(iter.Current())))
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+	return buildRibbonFilter(digests, opts), nil
+}
+
+func buildRibbonFilter(digests []Digest, opts RibbonOptions) *RibbonFilter {
+	slots := uint64(math.Ceil(float64(len(digests)) * (1 + opts.Overhead)))
+	blocks := (slots + ribbonWidth - 1) / ribbonWidth
+	if blocks < 1 {
+		blocks = 1
+	}
+	t := ribbonTable{
+		count:      uint64(len(digests)),
+		blocks:     blocks,
+		resultBits: uint64(opts.ResultBits),
+		data:       make([]byte, 8*blocks*uint64(opts.ResultBits)),
+		hasher:     opts.Hasher,
+	}
+
+	// Band the equations by Gaussian elimination as they are added, each
+	// row i holds an equation whose first coefficient is slot i.
+	rows := make([]uint64, blocks*ribbonWidth)
+	for _, h := range digests {
+		start, coeff := t.locate(h)
+		for coeff != 0 {
+			if rows[start] == 0 {
+				rows[start] = coeff
+				break
+			}
+			coeff ^= rows[start]
+			if coeff == 0 {
+				// The equation follows from the equations already added.
+				break
+			}
+			shift := uint64(bits.TrailingZeros64(coeff))
+			start += shift
+			coeff >>= shift
+		}
+	}
+
+	// Solve by back substitution, the results of the slots without an
+	// equation are free and chosen pseudo randomly so that values not in
+	// the set are rejected.
+	mask := uint32(1<<opts.ResultBits - 1)
+	results := make([]uint32, len(rows))
+	for i := len(rows) - 1; i >= 0; i-- {
+		if rows[i] == 0 {
+			results[i] = uint32(splitmix64(uint64(i))) & mask
+			continue
+		}
+		var result uint32
+		for rest := rows[i] &^ 1; rest != 0; rest &= rest - 1 {
+			result ^= results[i+bits.TrailingZeros64(rest)]
+		}
+		results[i] = result
+	}
+
+	for block := uint64(0); block < blocks; block++ {
+		for k := uint64(0); k < t.resultBits; k++ {
+			var word uint64
+			for j := uint64(0); j < ribbonWidth; j++ {
+				word |= uint64(results[block*ribbonWidth+j]>>k&1) << j
+			}
+			binary.LittleEndian.PutUint64(t.data[8*(block*t.resultBits+k):], word)
+		}
+	}
+	return &RibbonFilter{ribbonTable: t}
+}
+
+// Test if value is in the set.
+func (b *RibbonFilter) Test(value []byte) bool {
+	return b.test(Digest(b.hasher.Sum(value)))
+}
+
+// TestDigest tests if the value with a digest is in the set, the digest
+// must have been computed with the hasher of the filter.
+func (b *RibbonFilter) TestDigest(h Digest) bool {
+	return b.test(h)
+}
+
+// Count returns the number of keys the filter was built from.
+func (b *RibbonFilter) Count() uint64 {
+	return b.count
+}
+
+// ResultBits returns the number of bits stored per slot.
+func (b *RibbonFilter) ResultBits() uint {
+	return uint(b.resultBits)
+}
+
+// Hasher returns the hasher used.
+func (b *RibbonFilter) Hasher() Hasher {
+	return b.hasher
+}
+
+// WriteTo writes the ribbon filter to a stream with a header describing
+// its size and the hash scheme.
+func (b *RibbonFilter) WriteTo(w io.Writer) (int64, error) {
+	var buf [ribbonHeaderLen]byte
+	b.encodeHeader(buf[:])
+	cw := &countingWriter{w: w}
+	if _, err := cw.Write(buf[:]); err != nil {
+		return cw.n, err
+	}
+	_, err := cw.Write(b.data)
+	return cw.n, err
+}
+
+// ConcurrentReadOnlyRibbonFilter is a concurrent read only ribbon filter
+// set membership backed by a byte slice, this means it can be used with a
+// mmap'd bytes ref. It can be concurrently read from by any number of
+// readers.
+type ConcurrentReadOnlyRibbonFilter struct {
+	ribbonTable
+	checksum *storedChecksum
+}
+
+// NewConcurrentReadOnlyRibbonFilterFromBytes returns a new concurrent read
+// only ribbon filter from data previously written with
+// RibbonFilter.WriteTo, the solution is not copied so data can be a mmap'd
+// bytes ref. It can be concurrently read from by any number of readers.
+func NewConcurrentReadOnlyRibbonFilterFromBytes(
+	data []byte,
+	opts ParseOptions,
+) (*ConcurrentReadOnlyRibbonFilter, error) {
+	if len(data) < ribbonHeaderLen {
+		return nil, ErrTruncated
+	}
+	t, err := parseRibbonHeader(data, opts.Hasher)
+	if err != nil {
+		return nil, err
+	}
+	size := 8 * t.blocks * t.resultBits
+	if uint64(len(data)-ribbonHeaderLen) < size {
+		return nil, ErrTruncated
+	}
+	t.data = data[ribbonHeaderLen : ribbonHeaderLen+int(size)]
+	sum := &storedChecksum{
+		header:  data[:ribbonChecksumOffset],
+		payload: t.data,
+		value:   binary.LittleEndian.Uint32(data[32:36]),
+	}
+	if opts.VerifyChecksum {
+		if err := sum.verify(); err != nil {
+			return nil, err
+		}
+	}
+	return &ConcurrentReadOnlyRibbonFilter{ribbonTable: t, checksum: sum}, nil
+}
+
+// Test if value is in the set.
+func (b *ConcurrentReadOnlyRibbonFilter) Test(value []byte) bool {
+	return b.test(Digest(b.hasher.Sum(value)))
+}
+
+// TestDigest tests if the value with a digest is in the set, the digest
+// must have been computed with the hasher of the filter.
+func (b *ConcurrentReadOnlyRibbonFilter) TestDigest(h Digest) bool {
+	return b.test(h)
+}
+
+// Count returns the number of keys the filter was built from.
+func (b *ConcurrentReadOnlyRibbonFilter) Count() uint64 {
+	return b.count
+}
+
+// ResultBits returns the number of bits stored per slot.
+func (b *ConcurrentReadOnlyRibbonFilter) ResultBits() uint {
+	return uint(b.resultBits)
+}
+
+// Hasher returns the hasher used.
+func (b *ConcurrentReadOnlyRibbonFilter) Hasher() Hasher {
+	return b.hasher
+}
+
+// Verify verifies the solution of the filter against the checksum stored
+// when it was serialized.
+func (b *ConcurrentReadOnlyRibbonFilter) Verify() error {
+	return b.checksum.verify()
+}
+
+func (t *ribbonTable) encodeHeader(buf []byte) {
+	binary.LittleEndian.PutUint32(buf[0:4], ribbonHeaderMagic)
+	binary.LittleEndian.PutUint16(buf[4:6], formatVersion)
+	binary.LittleEndian.PutUint16(buf[6:8], uint16(t.hasher.Scheme()))
+	binary.LittleEndian.PutUint64(buf[8:16], t.count)
+	binary.LittleEndian.PutUint64(buf[16:24], t.blocks)
+	binary.LittleEndian.PutUint32(buf[24:28], uint32(t.resultBits))
+	binary.LittleEndian.PutUint32(buf[28:32], 0)
+	binary.LittleEndian.PutUint32(buf[32:36],
+		checksum(buf[:ribbonChecksumOffset], t.data))
+	binary.LittleEndian.PutUint32(buf[36:40], 0)
+}
+
+// parseRibbonHeader parses the header of a ribbon filter, returning its
+// table without the solution.
+func parseRibbonHeader(data []byte, expected Hasher) (ribbonTable, error) {
+	if binary.LittleEndian.Uint32(data[0:4]) != ribbonHeaderMagic {
+		return ribbonTable{}, ErrInvalidMagic
+	}
+	if v := binary.LittleEndian.Uint16(data[4:6]); v != formatVersion {
+		return ribbonTable{}, fmt.Errorf("%w: %d", ErrUnsupportedVersion, v)
+	}
+	hasher, err := resolveHasher(HashScheme(binary.LittleEndian.Uint16(data[6:8])), expected)
+	if err != nil {
+		return ribbonTable{}, err
+	}
+	t := ribbonTable{
+		count:      binary.LittleEndian.Uint64(data[8:16]),
+		blocks:     binary.LittleEndian.Uint64(data[16:24]),
+		resultBits: uint64(binary.LittleEndian.Uint32(data[24:28])),
+		hasher:     hasher,
+	}
+	if t.resultBits == 0 || t.resultBits > maxRibbonResultBits {
+		return ribbonTable{}, fmt.Errorf("%w: %d", ErrInvalidResultBits, t.resultBits)
+	}
+	if t.blocks == 0 || t.blocks > math.MaxUint64/(8*ribbonWidth*t.resultBits) {
+		return ribbonTable{}, fmt.Errorf("%w: %d blocks", ErrPayloadLength, t.blocks)
+	}
+	return t, nil
+}
@@ -0,0 +1,174 @@
+package bloom
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type sliceKeyIterator struct {
+	keys [][]byte
+	idx  int
+	err  error
+}
+
+func (it *sliceKeyIterator) Next() bool {
+	if it.idx >= len(it.keys) {
+		return false
+	}
+	it.idx++
+	return true
+}
+
+func (it *sliceKeyIterator) Current() []byte { return it.keys[it.idx-1] }
+func (it *sliceKeyIterator) Err() error      { return it.err }
+
+func TestRibbonFilter(t *testing.T) {
+	for _, n := range []int{0, 1, 10, 1000, 100000} {
+		t.Run(fmt.Sprintf("%d keys", n), func(t *testing.T) {
+			keys := newTestFuseKeys(n)
+			f, err := BuildRibbonFilter(keys, RibbonOptions{})
+			require.NoError(t, err)
+			require.Equal(t, uint64(n), f.Count())
+			require.Equal(t, uint(8), f.ResultBits())
+			for _, key := range keys {
+				require.True(t, f.Test(key))
+			}
+		})
+	}
+}
+
+func TestRibbonFilterFalsePositiveRate(t *testing.T) {
+	const n = 100000
+	for _, r := range []uint{1, 4, 8} {
+		t.Run(fmt.Sprintf("%d result bits", r), func(t *testing.T) {
+			f, err := BuildRibbonFilter(newTestFuseKeys(n), RibbonOptions{ResultBits: r})
+			require.NoError(t, err)
+
+			var fp int
+			for i := 0; i < n; i++ {
+				if f.Test([]byte(fmt.Sprintf("other-%d", i))) {
+					fp++
+				}
+			}
+			expected := math.Pow(2, -float64(r))
+			require.InDelta(t, expected, float64(fp)/n, 0.15*expected)
+
+			bitsPerKey := float64(8*len(f.data)) / n
+			require.InDelta(t, 1.1*float64(r), bitsPerKey, 0.05*float64(r))
+		})
+	}
+}
+
+func TestRibbonFilterFromIterator(t *testing.T) {
+	keys := newTestFuseKeys(1000)
+	expected, err := BuildRibbonFilter(keys, RibbonOptions{})
+	require.NoError(t, err)
+
+	f, err := BuildRibbonFilterFromIterator(&sliceKeyIterator{keys: keys}, RibbonOptions{})
+	require.NoError(t, err)
+	require.Equal(t, expected.data, f.data)
+
+	iterErr := errors.New("iterator error")
+	_, err = BuildRibbonFilterFromIterator(&sliceKeyIterator{keys: keys, err: iterErr},
+		RibbonOptions{})
+	require.Equal(t, iterErr, err)
+}
+
+func TestRibbonFilterOptions(t *testing.T) {
+	_, err := BuildRibbonFilter(nil, RibbonOptions{ResultBits: 33})
+	require.True(t, errors.Is(err, ErrInvalidResultBits))
+	_, err = BuildRibbonFilter(nil, RibbonOptions{Overhead: -1})
+	require.True(t, errors.Is(err, ErrInvalidOverhead))
+	_, err = BuildRibbonFilter(nil, RibbonOptions{Overhead: math.NaN()})
+	require.True(t, errors.Is(err, ErrInvalidOverhead))
+}
+
+func TestRibbonFilterWriteTo(t *testing.T) {
+	keys := newTestFuseKeys(1000)
+	f, err := BuildRibbonFilter(keys, RibbonOptions{
+		ResultBits: 11,
+		Hasher:     WyhashHasher,
+	})
+	require.NoError(t, err)
+
+	buf := bytes.NewBuffer(nil)
+	written, err := f.WriteTo(buf)
+	require.NoError(t, err)
+	require.Equal(t, int64(buf.Len()), written)
+
+	ro, err := NewConcurrentReadOnlyRibbonFilterFromBytes(buf.Bytes(),
+		ParseOptions{VerifyChecksum: true})
+	require.NoError(t, err)
+	require.Equal(t, f.Count(), ro.Count())
+	require.Equal(t, f.ResultBits(), ro.ResultBits())
+	require.Equal(t, HashSchemeWyhash, ro.Hasher().Scheme())
+	require.NoError(t, ro.Verify())
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for _, key := range keys {
+				require.True(t, ro.Test(key))
+			}
+		}()
+	}
+	wg.Wait()
+
+	buf.Bytes()[buf.Len()-1] ^= 0x80
+	require.True(t, errors.Is(ro.Verify(), ErrChecksumMismatch))
+}
+
+func TestRibbonFilterHeaderErrors(t *testing.T) {
+	f, err := BuildRibbonFilter(newTestFuseKeys(100), RibbonOptions{})
+	require.NoError(t, err)
+	buf := bytes.NewBuffer(nil)
+	_, err = f.WriteTo(buf)
+	require.NoError(t, err)
+	data := buf.Bytes()
+
+	corrupt := func(offset int, value byte) []byte {
+		c := append([]byte(nil), data...)
+		c[offset] = value
+		return c
+	}
+
+	tests := []struct {
+		name     string
+		data     []byte
+		expected error
+	}{
+		{name: "short header", data: data[:ribbonHeaderLen-1], expected: ErrTruncated},
+		{name: "short payload", data: data[:len(data)-1], expected: ErrTruncated},
+		{name: "magic", data: corrupt(0, 'X'), expected: ErrInvalidMagic},
+		{name: "version", data: corrupt(4, 0xff), expected: ErrUnsupportedVersion},
+		{name: "hash scheme", data: corrupt(6, 0xff), expected: ErrUnsupportedHashScheme},
+		{name: "blocks", data: corrupt(16, 0), expected: ErrPayloadLength},
+		{name: "result bits", data: corrupt(24, 33), expected: ErrInvalidResultBits},
+		{name: "checksum", data: corrupt(8, data[8]+1), expected: ErrChecksumMismatch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewConcurrentReadOnlyRibbonFilterFromBytes(tt.data,
+				ParseOptions{VerifyChecksum: true})
+			require.True(t, errors.Is(err, tt.expected), "unexpected error: %v", err)
+		})
+	}
+}
+
+func BenchmarkBuildRibbonFilter100k(b *testing.B) {
+	keys := newTestFuseKeys(100 * 1000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := BuildRibbonFilter(keys, RibbonOptions{}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
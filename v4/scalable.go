@@ -0,0 +1,404 @@
+package bloom
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+const (
+	// scalableHeaderMagic identifies a serialized scalable bloom filter,
+	// it is "M3SB" when read as little endian bytes.
+	scalableHeaderMagic uint32 = 0x4253334d
+	// scalableHeaderLen is the length in bytes of a serialized scalable
+	// bloom filter header.
+	scalableHeaderLen = 48
+	// scalableChecksumOffset is the offset of the checksum in a serialized
+	// scalable bloom filter header, the bytes before it are covered by it.
+	scalableChecksumOffset = 40
+	// scalableStagePrefixLen is the length in bytes of the count of values
+	// that precedes each serialized stage.
+	scalableStagePrefixLen = 8
+
+	defaultScalableGrowthFactor    = 2
+	defaultScalableTighteningRatio = 0.85
+)
+
+var (
+	// ErrInvalidTighteningRatio is returned when creating a scalable bloom
+	// filter with a tightening ratio outside of (0, 1).
+	ErrInvalidTighteningRatio = errors.New("bloom: tightening ratio must be in (0, 1)")
+	// ErrCapacityExceeded is returned when adding a value to a scalable
+	// bloom filter whose last stage is full when no further stage can be
+	// planned, either because its capacity or size cannot be represented
+	// or because its false positive rate underflows.
+	ErrCapacityExceeded = errors.New("bloom: scalable bloom filter capacity exceeded")
+)
+
+// ScalableOptions are options for creating a scalable bloom filter.
+type ScalableOptions struct {
+	// InitialCapacity is the number of values the first stage holds.
+	InitialCapacity uint
+	// FalsePositiveRate is the bound of the false positive rate of the
+	// filter over all of its stages.
+	FalsePositiveRate float64
+	// GrowthFactor is the factor the capacity of each stage grows by over
+	// the previous stage, or zero for a factor of 2.
+	GrowthFactor uint
+	// TighteningRatio is the ratio of the false positive rate of each stage
+	// to that of the previous stage, or zero for a ratio of 0.85.
+	TighteningRatio float64
+	// Hasher is the hasher used, or nil for Murmur3Hasher.
+	Hasher Hasher
+}
+
+// ScalableBloomFilter is a bloom filter set membership that grows as values
+// are added, as described by Almeida et al. in "Scalable Bloom Filters".
+// Values are added to the last of a chain of bloom filter stages, once the
+// last stage holds its capacity a new stage is added with a capacity
+// GrowthFactor times larger and a false positive rate TighteningRatio
+// times smaller. The false positive rates of the stages form a geometric
+// series so that the false positive rate of the filter is bounded by
+// FalsePositiveRate however many stages are added. Stages are never filled
+// beyond their capacity, if no further stage can be planned Add returns
+// ErrCapacityExceeded rather than weaken the bound.
+// It cannot be concurrently read or written to, a sync.Mutex must be used
+// to guard read/write access if desired.
+type ScalableBloomFilter struct {
+	opts     ScalableOptions
+	stages   []*BloomFilter
+	counts   []uint64
+	capacity uint64
+}
+
+// NewScalableBloomFilter creates a new scalable bloom filter.
+// It is not concurrent read or write safe.
+func NewScalableBloomFilter(opts ScalableOptions) (*ScalableBloomFilter, error) {
+	opts, err := opts.withDefaults()
+	if err != nil {
+		return nil, err
+	}
+	b := &ScalableBloomFilter{opts: opts}
+	if err := b.addStage(); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func (o ScalableOptions) withDefaults() (ScalableOptions, error) {
+	if o.GrowthFactor == 0 {
+		o.GrowthFactor = defaultScalableGrowthFactor
+	}
+	if o.TighteningRatio == 0 {
+		o.TighteningRatio = defaultScalableTighteningRatio
+	}
+	if o.Hasher == nil {
+		o.Hasher = Murmur3Hasher
+	}
+	if o.InitialCapacity == 0 {
+		return o, ErrZeroN
+	}
+	if err := validateFalsePositiveRate(o.FalsePositiveRate); err != nil {
+		return o, err
+	}
+	if !(o.TighteningRatio > 0 && o.TighteningRatio < 1) {
+		return o, fmt.Errorf("%w: %v", ErrInvalidTighteningRatio, o.TighteningRatio)
+	}
+	return o, nil
+}
+
+// stagePlan returns the plan of stage i, whose capacity is InitialCapacity
+// times GrowthFactor^i and whose false positive rate is
+// FalsePositiveRate * (1 - TighteningRatio) * TighteningRatio^i.
+func (o ScalableOptions) stagePlan(i int) (Plan, error) {
+	capacity := float64(o.InitialCapacity) * math.Pow(float64(o.GrowthFactor), float64(i))
+	// float64(maxUint) rounds up to 2^64 or 2^32, which itself does not
+	// fit in a uint.
+	if capacity >= float64(maxUint) {
+		return Plan{}, fmt.Errorf("%w: stage %d capacity %v", ErrCapacityExceeded, i, capacity)
+	}
+	p := o.FalsePositiveRate * (1 - o.TighteningRatio) *
+		math.Pow(o.TighteningRatio, float64(i))
+	return PlanForFalsePositiveRate(uint(capacity), p)
+}
+
+func (b *ScalableBloomFilter) addStage() error {
+	plan, err := b.opts.stagePlan(len(b.stages))
+	if err != nil {
+		return err
+	}
+	b.stages = append(b.stages, NewBloomFilterWithHasher(plan.M, plan.K, b.opts.Hasher))
+	b.counts = append(b.counts, 0)
+	b.capacity = uint64(plan.N)
+	return nil
+}
+
+// Add value to the set, it returns ErrCapacityExceeded without adding the
+// value if the last stage is full and no further stage can be planned.
+func (b *ScalableBloomFilter) Add(value []byte) error {
+	return b.AddDigest(Digest(b.opts.Hasher.Sum(value)))
+}
+
+// AddDigest adds the value with a digest to the set like Add, the digest
+// must have been computed with the hasher of the filter.
+func (b *ScalableBloomFilter) AddDigest(h Digest) error {
+	last := len(b.stages) - 1
+	if b.counts[last] >= b.capacity {
+		if err := b.addStage(); err != nil {
+			if errors.Is(err, ErrCapacityExceeded) {
+				return err
+			}
+			return fmt.Errorf("%w: %v", ErrCapacityExceeded, err)
+		}
+		last++
+	}
+	b.stages[last].AddDigest(h)
+	b.counts[last]++
+	return nil
+}
+
+// Test if value is in the set.
+func (b *ScalableBloomFilter) Test(value []byte) bool {
+	return b.TestDigest(Digest(b.opts.Hasher.Sum(value)))
+}
+
+// TestDigest tests if the value with a digest is in the set, the
+// digest must have been computed with the hasher of the filter.
+func (b *ScalableBloomFilter) TestDigest(h Digest) bool {
+	// Later stages are larger and hold more values so test them first.
+	for i := len(b.stages) - 1; i >= 0; i-- {
+		if b.stages[i].TestDigest(h) {
+			return true
+		}
+	}
+	return false
+}
+
+// Stages returns the bloom filter stages, the last of which values are
+// added to. The stages must not be modified.
+func (b *ScalableBloomFilter) Stages() []*BloomFilter {
+	return b.stages
+}
+
+// Count returns the number of values added.
+func (b *ScalableBloomFilter) Count() uint64 {
+	var n uint64
+	for _, c := range b.counts {
+		n += c
+	}
+	return n
+}
+
+// Hasher returns the hasher used.
+func (b *ScalableBloomFilter) Hasher() Hasher {
+	return b.opts.Hasher
+}
+
+// WriteTo writes the scalable bloom filter to a stream, with a header
+// followed by each of its stages in the format of BloomFilter.WriteTo.
+func (b *ScalableBloomFilter) WriteTo(w io.Writer) (int64, error) {
+	var buf [scalableHeaderLen]byte
+	encodeScalableHeader(buf[:], b.opts, len(b.stages))
+
+	cw := &countingWriter{w: w}
+	if _, err := cw.Write(buf[:]); err != nil {
+		return cw.n, err
+	}
+	for i, stage := range b.stages {
+		var prefix [scalableStagePrefixLen]byte
+		binary.LittleEndian.PutUint64(prefix[:], b.counts[i])
+		if _, err := cw.Write(prefix[:]); err != nil {
+			return cw.n, err
+		}
+		if _, err := stage.WriteTo(cw); err != nil {
+			return cw.n, err
+		}
+	}
+	return cw.n, nil
+}
+
+// ReadFrom reads a scalable bloom filter previously written with WriteTo
+// from a stream, replacing the contents of the scalable bloom filter.
+func (b *ScalableBloomFilter) ReadFrom(r io.Reader) (int64, error) {
+	var buf [scalableHeaderLen]byte
+	n, err := io.ReadFull(r, buf[:])
+	read := int64(n)
+	if err != nil {
+		return read, readErr(err)
+	}
+	opts, numStages, err := parseScalableHeader(buf[:], nil)
+	if err != nil {
+		return read, err
+	}
+
+	var (
+		stages []*BloomFilter
+		counts []uint64
+	)
+	for i := 0; i < numStages; i++ {
+		var prefix [scalableStagePrefixLen]byte
+		n, err := io.ReadFull(r, prefix[:])
+		read += int64(n)
+		if err != nil {
+			return read, readErr(err)
+		}
+		stage := &BloomFilter{hasher: opts.Hasher}
+		stageRead, err := stage.ReadFrom(r)
+		read += stageRead
+		if err != nil {
+			return read, err
+		}
+		stages = append(stages, stage)
+		counts = append(counts, binary.LittleEndian.Uint64(prefix[:]))
+	}
+
+	b.opts = opts
+	b.stages = stages
+	b.counts = counts
+	// A last stage that cannot be planned is treated as full, so the next
+	// add plans a further stage or returns ErrCapacityExceeded.
+	b.capacity = 0
+	if plan, err := opts.stagePlan(numStages - 1); err == nil {
+		b.capacity = uint64(plan.N)
+	}
+	return read, nil
+}
+
+// ConcurrentReadOnlyScalableBloomFilter is a concurrent read only scalable
+// bloom filter set membership backed by a byte slice, this means it can be
+// used with a mmap'd bytes ref. It can be concurrently read from by any
+// number of readers.
+type ConcurrentReadOnlyScalableBloomFilter struct {
+	hasher Hasher
+	stages []*ConcurrentReadOnlyBloomFilter
+}
+
+// NewConcurrentReadOnlyScalableBloomFilterFromBytes returns a new concurrent
+// read only scalable bloom filter from data previously written with
+// ScalableBloomFilter.WriteTo, the bits are not copied so data can be a
+// mmap'd bytes ref. It can be concurrently read from by any number of
+// readers.
+func NewConcurrentReadOnlyScalableBloomFilterFromBytes(
+	data []byte,
+	opts ParseOptions,
+) (*ConcurrentReadOnlyScalableBloomFilter, error) {
+	scalableOpts, numStages, err := parseScalableHeader(data, opts.Hasher)
+	if err != nil {
+		return nil, err
+	}
+	opts.Hasher = scalableOpts.Hasher
+
+	data = data[scalableHeaderLen:]
+	var stages []*ConcurrentReadOnlyBloomFilter
+	for i := 0; i < numStages; i++ {
+		if len(data) < scalableStagePrefixLen {
+			return nil, ErrTruncated
+		}
+		data = data[scalableStagePrefixLen:]
+		stage, err := NewConcurrentReadOnlyBloomFilterFromBytes(data, opts)
+		if err != nil {
+			return nil, err
+		}
+		stages = append(stages, stage)
+		data = data[headerLen+len(stage.data):]
+	}
+
+	return &ConcurrentReadOnlyScalableBloomFilter{
+		hasher: scalableOpts.Hasher,
+		stages: stages,
+	}, nil
+}
+
+// Test if value is in the set.
+func (b *ConcurrentReadOnlyScalableBloomFilter) Test(value []byte) bool {
+	return b.TestDigest(Digest(b.hasher.Sum(value)))
+}
+
+// TestDigest tests if the value with a digest is in the set, the
+// digest must have been computed with the hasher of the filter.
+func (b *ConcurrentReadOnlyScalableBloomFilter) TestDigest(h Digest) bool {
+	for i := len(b.stages) - 1; i >= 0; i-- {
+		if b.stages[i].TestDigest(h) {
+			return true
+		}
+	}
+	return false
+}
+
+// Stages returns the bloom filter stages.
+func (b *ConcurrentReadOnlyScalableBloomFilter) Stages() []*ConcurrentReadOnlyBloomFilter {
+	return b.stages
+}
+
+// Hasher returns the hasher used.
+func (b *ConcurrentReadOnlyScalableBloomFilter) Hasher() Hasher {
+	return b.hasher
+}
+
+// Verify verifies the bits of each stage against the checksum stored
+// when it was serialized.
+func (b *ConcurrentReadOnlyScalableBloomFilter) Verify() error {
+	for _, stage := range b.stages {
+		if err := stage.Verify(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func encodeScalableHeader(buf []byte, opts ScalableOptions, numStages int) {
+	binary.LittleEndian.PutUint32(buf[0:4], scalableHeaderMagic)
+	binary.LittleEndian.PutUint16(buf[4:6], formatVersion)
+	binary.LittleEndian.PutUint16(buf[6:8], uint16(opts.Hasher.Scheme()))
+	binary.LittleEndian.PutUint32(buf[8:12], uint32(numStages))
+	binary.LittleEndian.PutUint32(buf[12:16], uint32(opts.GrowthFactor))
+	binary.LittleEndian.PutUint64(buf[16:24], uint64(opts.InitialCapacity))
+	binary.LittleEndian.PutUint64(buf[24:32], math.Float64bits(opts.FalsePositiveRate))
+	binary.LittleEndian.PutUint64(buf[32:40], math.Float64bits(opts.TighteningRatio))
+	binary.LittleEndian.PutUint32(buf[40:44],
+		checksum(buf[:scalableChecksumOffset], nil))
+	binary.LittleEndian.PutUint32(buf[44:48], 0)
+}
+
+func parseScalableHeader(
+	data []byte,
+	expected Hasher,
+) (ScalableOptions, int, error) {
+	if len(data) < scalableHeaderLen {
+		return ScalableOptions{}, 0, ErrTruncated
+	}
+	if binary.LittleEndian.Uint32(data[0:4]) != scalableHeaderMagic {
+		return ScalableOptions{}, 0, ErrInvalidMagic
+	}
+	if v := binary.LittleEndian.Uint16(data[4:6]); v != formatVersion {
+		return ScalableOptions{}, 0, fmt.Errorf("%w: %d", ErrUnsupportedVersion, v)
+	}
+	sum := &storedChecksum{
+		header: data[:scalableChecksumOffset],
+		value:  binary.LittleEndian.Uint32(data[40:44]),
+	}
+	if err := sum.verify(); err != nil {
+		return ScalableOptions{}, 0, err
+	}
+	hasher, err := resolveHasher(HashScheme(binary.LittleEndian.Uint16(data[6:8])), expected)
+	if err != nil {
+		return ScalableOptions{}, 0, err
+	}
+	opts := ScalableOptions{
+		InitialCapacity:   uint(binary.LittleEndian.Uint64(data[16:24])),
+		FalsePositiveRate: math.Float64frombits(binary.LittleEndian.Uint64(data[24:32])),
+		GrowthFactor:      uint(binary.LittleEndian.Uint32(data[12:16])),
+		TighteningRatio:   math.Float64frombits(binary.LittleEndian.Uint64(data[32:40])),
+		Hasher:            hasher,
+	}
+	if opts, err = opts.withDefaults(); err != nil {
+		return ScalableOptions{}, 0, err
+	}
+	numStages := int(binary.LittleEndian.Uint32(data[8:12]))
+	if numStages == 0 {
+		return ScalableOptions{}, 0, fmt.Errorf("%w: no stages", ErrTruncated)
+	}
+	return opts, numStages, nil
+}
@@ -0,0 +1,172 @@
+package bloom
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestScalableBloomFilter(t *testing.T) {
+	const (
+		n = 20000
+		p = 0.01
+	)
+	f, err := NewScalableBloomFilter(ScalableOptions{
+		InitialCapacity:   1000,
+		FalsePositiveRate: p,
+	})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(f.Stages()))
+
+	for i := 0; i < n; i++ {
+		require.NoError(t, f.Add([]byte(fmt.Sprintf("series-%d", i))))
+	}
+	require.Equal(t, uint64(n), f.Count())
+	// 1000 + 2000 + 4000 + 8000 < 20000 <= 1000 + ... + 16000
+	require.Equal(t, 5, len(f.Stages()))
+	for i := 0; i < n; i++ {
+		require.True(t, f.Test([]byte(fmt.Sprintf("series-%d", i))))
+	}
+
+	falsePositives := 0
+	const trials = 100000
+	for i := 0; i < trials; i++ {
+		if f.Test([]byte(fmt.Sprintf("other-%d", i))) {
+			falsePositives++
+		}
+	}
+	require.True(t, float64(falsePositives)/trials < p,
+		"false positive rate %v exceeds %v", float64(falsePositives)/trials, p)
+}
+
+func TestScalableBloomFilterSerialization(t *testing.T) {
+	f, err := NewScalableBloomFilter(ScalableOptions{
+		InitialCapacity:   100,
+		FalsePositiveRate: 0.01,
+		GrowthFactor:      4,
+		TighteningRatio:   0.5,
+		Hasher:            WyhashHasher,
+	})
+	require.NoError(t, err)
+	for i := 0; i < 1000; i++ {
+		require.NoError(t, f.Add([]byte(fmt.Sprintf("series-%d", i))))
+	}
+
+	buf := bytes.NewBuffer(nil)
+	written, err := f.WriteTo(buf)
+	require.NoError(t, err)
+	require.Equal(t, int64(buf.Len()), written)
+	data := buf.Bytes()
+
+	var r ScalableBloomFilter
+	read, err := r.ReadFrom(bytes.NewReader(data))
+	require.NoError(t, err)
+	require.Equal(t, written, read)
+	require.Equal(t, f.Count(), r.Count())
+	require.Equal(t, len(f.Stages()), len(r.Stages()))
+	require.Equal(t, WyhashHasher, r.Hasher())
+
+	ro, err := NewConcurrentReadOnlyScalableBloomFilterFromBytes(data, ParseOptions{
+		VerifyChecksum: true,
+	})
+	require.NoError(t, err)
+	require.Equal(t, len(f.Stages()), len(ro.Stages()))
+	require.NoError(t, ro.Verify())
+
+	for i := 0; i < 1000; i++ {
+		v := []byte(fmt.Sprintf("series-%d", i))
+		require.True(t, r.Test(v))
+		require.True(t, ro.Test(v))
+	}
+	for i := 0; i < 1000; i++ {
+		v := []byte(fmt.Sprintf("other-%d", i))
+		require.Equal(t, f.Test(v), r.Test(v))
+		require.Equal(t, f.Test(v), ro.Test(v))
+	}
+
+	// The read filter continues to grow from where it was written.
+	for i := 1000; i < 2000; i++ {
+		require.NoError(t, r.Add([]byte(fmt.Sprintf("series-%d", i))))
+	}
+	require.Equal(t, uint64(2000), r.Count())
+	require.True(t, r.Test([]byte("series-1999")))
+
+	_, err = NewConcurrentReadOnlyScalableBloomFilterFromBytes(data[:len(data)-1], ParseOptions{})
+	require.True(t, errors.Is(err, ErrTruncated), "unexpected error: %v", err)
+	_, err = NewConcurrentReadOnlyScalableBloomFilterFromBytes(data, ParseOptions{
+		Hasher: Murmur3Hasher,
+	})
+	require.True(t, errors.Is(err, ErrHashSchemeMismatch), "unexpected error: %v", err)
+
+	corrupt := append([]byte(nil), data...)
+	corrupt[16]++
+	_, err = r.ReadFrom(bytes.NewReader(corrupt))
+	require.True(t, errors.Is(err, ErrChecksumMismatch), "unexpected error: %v", err)
+}
+
+func TestScalableBloomFilterCapacityExceeded(t *testing.T) {
+	// The false positive rate of the third stage underflows to zero, so
+	// only the first two stages of 10 and 20 values can be planned.
+	f, err := NewScalableBloomFilter(ScalableOptions{
+		InitialCapacity:   10,
+		FalsePositiveRate: 0.01,
+		TighteningRatio:   1e-200,
+	})
+	require.NoError(t, err)
+	for i := 0; i < 30; i++ {
+		require.NoError(t, f.Add([]byte(fmt.Sprintf("series-%d", i))))
+	}
+	err = f.Add([]byte("series-30"))
+	require.True(t, errors.Is(err, ErrCapacityExceeded), "unexpected error: %v", err)
+	require.Equal(t, uint64(30), f.Count())
+	require.Equal(t, 2, len(f.Stages()))
+	require.False(t, f.Test([]byte("series-30")))
+
+	// Stages whose capacity does not fit in a uint cannot be planned.
+	opts := ScalableOptions{
+		InitialCapacity:   math.MaxUint32,
+		FalsePositiveRate: 0.01,
+		GrowthFactor:      math.MaxUint32,
+		TighteningRatio:   0.5,
+	}
+	_, err = opts.stagePlan(2)
+	require.True(t, errors.Is(err, ErrCapacityExceeded), "unexpected error: %v", err)
+}
+
+func TestScalableBloomFilterOptions(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     ScalableOptions
+		expected error
+	}{
+		{
+			name:     "capacity",
+			opts:     ScalableOptions{FalsePositiveRate: 0.01},
+			expected: ErrZeroN,
+		},
+		{
+			name:     "false positive rate",
+			opts:     ScalableOptions{InitialCapacity: 100, FalsePositiveRate: 1},
+			expected: ErrInvalidFalsePositiveRate,
+		},
+		{
+			name: "tightening ratio",
+			opts: ScalableOptions{
+				InitialCapacity:   100,
+				FalsePositiveRate: 0.01,
+				TighteningRatio:   1.5,
+			},
+			expected: ErrInvalidTighteningRatio,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewScalableBloomFilter(tt.opts)
+			require.True(t, errors.Is(err, tt.expected), "unexpected error: %v", err)
+		})
+	}
+}
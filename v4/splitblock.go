@@ -0,0 +1,519 @@
+package bloom
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+const (
+	// splitBlockBytes is the number of bytes in a split block bloom filter
+	// block, eight 32 bit words.
+	splitBlockBytes = 32
+	// minSplitBlockFilterBytes and maxSplitBlockFilterBytes are the bounds
+	// of the size of a split block bloom filter used by Parquet writers.
+	minSplitBlockFilterBytes = splitBlockBytes
+	maxSplitBlockFilterBytes = 128 * 1024 * 1024
+	// maxThriftDepth bounds the nesting of Thrift structs skipped when
+	// parsing a split block bloom filter header.
+	maxThriftDepth = 16
+)
+
+// ErrInvalidSplitBlockHeader is returned when parsing a split block bloom
+// filter header that is not a valid Parquet BloomFilterHeader, or which
+// describes an algorithm, hash or compression other than those of a split
+// block bloom filter.
+var ErrInvalidSplitBlockHeader = errors.New("bloom: invalid split block bloom filter header")
+
+// splitBlockSalt are the salts of the words of a block from the Parquet
+// split block bloom filter specification.
+var splitBlockSalt = [8]uint32{
+	0x47b6137b, 0x44974d91, 0x8824ad5b, 0xa2b7289d,
+	0x705495c7, 0x2df1424b, 0x9efc4947, 0x5c6bfb31,
+}
+
+// SplitBlockBloomFilter is a split block bloom filter set membership that
+// is byte for byte compatible with the bloom filters of Apache Parquet
+// column chunks. Values are hashed with xxHash64 with a seed of zero, the
+// upper 32 bits of the hash select a 256 bit block and the lower 32 bits
+// set one bit in each of the eight 32 bit words of the block, as described
+// by the Parquet specification. Values must be given in their Parquet plain
+// encoding to be found by other Parquet readers.
+// It cannot be concurrently read or written to, a sync.Mutex must be used
+// to guard read/write access if desired.
+type SplitBlockBloomFilter struct {
+	data []byte
+}
+
+// NewSplitBlockBloomFilter creates a new split block bloom filter of
+// numBytes bytes, rounded up to a power of two between 32 bytes and 128MiB
+// as Parquet writers do. It is not concurrent read or write safe.
+func NewSplitBlockBloomFilter(numBytes uint) *SplitBlockBloomFilter {
+	size := uint(minSplitBlockFilterBytes)
+	for size < numBytes && size < maxSplitBlockFilterBytes {
+		size <<= 1
+	}
+	return &SplitBlockBloomFilter{data: make([]byte, size)}
+}
+
+// SplitBlockBytesForFalsePositiveRate returns the number of bytes of a
+// split block bloom filter holding n values with a false positive rate of
+// at most about p, using the same estimate as Parquet writers.
+func SplitBlockBytesForFalsePositiveRate(n uint, p float64) (uint, error) {
+	if n == 0 {
+		return 0, ErrZeroN
+	}
+	if err := validateFalsePositiveRate(p); err != nil {
+		return 0, err
+	}
+	bits := -8 * float64(n) / math.Log(1-math.Pow(p, 1.0/8))
+	return uint(math.Ceil(bits / 8)), nil
+}
+
+// Add value to the set.
+func (b *SplitBlockBloomFilter) Add(value []byte) {
+	b.AddHash(xxhash64(value, 0))
+}
+
+// AddHash adds the value with a xxHash64 hash to the set.
+func (b *SplitBlockBloomFilter) AddHash(h uint64) {
+	block := b.data[splitBlockOffset(h, len(b.data)):]
+	key := uint32(h)
+	for i, salt := range splitBlockSalt {
+		word := block[4*i : 4*i+4]
+		binary.LittleEndian.PutUint32(word,
+			binary.LittleEndian.Uint32(word)|1<<((key*salt)>>27))
+	}
+}
+
+// Test if value is in the set.
+func (b *SplitBlockBloomFilter) Test(value []byte) bool {
+	return splitBlockTest(b.data, xxhash64(value, 0))
+}
+
+// TestHash tests if the value with a xxHash64 hash is in the set.
+func (b *SplitBlockBloomFilter) TestHash(h uint64) bool {
+	return splitBlockTest(b.data, h)
+}
+
+// M returns the m elements represented.
+func (b *SplitBlockBloomFilter) M() uint {
+	return uint(len(b.data)) * 8
+}
+
+// K returns the k hashes used.
+func (b *SplitBlockBloomFilter) K() uint {
+	return uint(len(splitBlockSalt))
+}
+
+// Bytes returns the bitset of the filter as stored after the
+// BloomFilterHeader in a Parquet file, it is not copied.
+func (b *SplitBlockBloomFilter) Bytes() []byte {
+	return b.data
+}
+
+// WriteTo writes the split block bloom filter to a stream as a Parquet
+// BloomFilterHeader followed by the bitset.
+func (b *SplitBlockBloomFilter) WriteTo(w io.Writer) (int64, error) {
+	n, err := w.Write(encodeSplitBlockHeader(len(b.data)))
+	if err != nil {
+		return int64(n), err
+	}
+	m, err := w.Write(b.data)
+	return int64(n + m), err
+}
+
+// ReadFrom reads a split block bloom filter written as a Parquet
+// BloomFilterHeader followed by the bitset from a stream, replacing the
+// contents of the split block bloom filter.
+func (b *SplitBlockBloomFilter) ReadFrom(r io.Reader) (int64, error) {
+	br, ok := r.(io.ByteReader)
+	if !ok {
+		br = &byteReader{r: r}
+	}
+	counted := &countingByteReader{r: br}
+	numBytes, err := parseSplitBlockHeader(counted)
+	if err != nil {
+		return counted.n, err
+	}
+	data := make([]byte, numBytes)
+	n, err := io.ReadFull(r, data)
+	if err != nil {
+		return counted.n + int64(n), readErr(err)
+	}
+	b.data = data
+	return counted.n + int64(n), nil
+}
+
+// ReadOnlySplitBlockBloomFilter is a read only split block bloom filter
+// set membership backed by a byte slice, this means it can be used with a
+// mmap'd Parquet column chunk bloom filter. It holds no mutable state so
+// it can be concurrently read from by any number of readers.
+type ReadOnlySplitBlockBloomFilter struct {
+	data []byte
+}
+
+// NewReadOnlySplitBlockBloomFilter returns a new read only split block
+// bloom filter from a bitset without a header, the bitset is not copied
+// so it can be a mmap'd bytes ref.
+func NewReadOnlySplitBlockBloomFilter(
+	bitset []byte,
+) (*ReadOnlySplitBlockBloomFilter, error) {
+	if len(bitset) == 0 || len(bitset)%splitBlockBytes != 0 {
+		return nil, fmt.Errorf("%w: %d bytes is not a whole number of blocks",
+			ErrPayloadLength, len(bitset))
+	}
+	return &ReadOnlySplitBlockBloomFilter{data: bitset}, nil
+}
+
+// NewReadOnlySplitBlockBloomFilterFromBytes returns a new read only split
+// block bloom filter from data starting with a Parquet BloomFilterHeader
+// followed by the bitset, as found at the bloom filter offset of a column
+// chunk. The bitset is not copied so data can be a mmap'd bytes ref, and
+// data may extend past the end of the bitset.
+func NewReadOnlySplitBlockBloomFilterFromBytes(
+	data []byte,
+) (*ReadOnlySplitBlockBloomFilter, error) {
+	r := bytes.NewReader(data)
+	numBytes, err := parseSplitBlockHeader(r)
+	if err != nil {
+		return nil, err
+	}
+	start := len(data) - r.Len()
+	if uint64(r.Len()) < numBytes {
+		return nil, ErrTruncated
+	}
+	return NewReadOnlySplitBlockBloomFilter(data[start : start+int(numBytes)])
+}
+
+// Test if value is in the set.
+func (b *ReadOnlySplitBlockBloomFilter) Test(value []byte) bool {
+	return splitBlockTest(b.data, xxhash64(value, 0))
+}
+
+// TestHash tests if the value with a xxHash64 hash is in the set.
+func (b *ReadOnlySplitBlockBloomFilter) TestHash(h uint64) bool {
+	return splitBlockTest(b.data, h)
+}
+
+// M returns the m elements represented.
+func (b *ReadOnlySplitBlockBloomFilter) M() uint {
+	return uint(len(b.data)) * 8
+}
+
+// K returns the k hashes used.
+func (b *ReadOnlySplitBlockBloomFilter) K() uint {
+	return uint(len(splitBlockSalt))
+}
+
+// splitBlockOffset returns the offset of the block of a hash in a bitset of
+// size bytes.
+func splitBlockOffset(h uint64, size int) int {
+	blocks := uint64(size / splitBlockBytes)
+	return int(((h>>32)*blocks)>>32) * splitBlockBytes
+}
+
+func splitBlockTest(data []byte, h uint64) bool {
+	block := data[splitBlockOffset(h, len(data)):]
+	key := uint32(h)
+	for i, salt := range splitBlockSalt {
+		word := binary.LittleEndian.Uint32(block[4*i : 4*i+4])
+		if word&(1<<((key*salt)>>27)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Thrift compact protocol field types.
+const (
+	thriftTrue   = 1
+	thriftFalse  = 2
+	thriftByte   = 3
+	thriftI16    = 4
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftDouble = 7
+	thriftBinary = 8
+	thriftList   = 9
+	thriftSet    = 10
+	thriftMap    = 11
+	thriftStruct = 12
+)
+
+// encodeSplitBlockHeader encodes the Parquet BloomFilterHeader of a split
+// block bloom filter of numBytes bytes with the Thrift compact protocol:
+//
+//	struct BloomFilterHeader {
+//	  1: required i32 numBytes;
+//	  2: required BloomFilterAlgorithm algorithm;     // BLOCK
+//	  3: required BloomFilterHash hash;               // XXHASH
+//	  4: required BloomFilterCompression compression; // UNCOMPRESSED
+//	}
+//
+// Each of the unions has its first field set to an empty struct.
+func encodeSplitBlockHeader(numBytes int) []byte {
+	var varint [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(varint[:], zigzag32(int32(numBytes)))
+	buf := make([]byte, 0, 32)
+	buf = append(buf, 1<<4|thriftI32)
+	buf = append(buf, varint[:n]...)
+	for i := 0; i < 3; i++ {
+		buf = append(buf, 1<<4|thriftStruct, 1<<4|thriftStruct, 0, 0)
+	}
+	return append(buf, 0)
+}
+
+// parseSplitBlockHeader parses a Parquet BloomFilterHeader, returning the
+// length of the bitset that follows it.
+func parseSplitBlockHeader(r io.ByteReader) (uint64, error) {
+	var (
+		numBytes    int64
+		hasNumBytes bool
+		unions      int
+		id          int16
+	)
+	for {
+		typ, err := readThriftField(r, &id)
+		if err != nil {
+			return 0, err
+		}
+		if typ == 0 {
+			break
+		}
+		switch {
+		case id == 1 && typ == thriftI32:
+			v, err := readThriftInt(r)
+			if err != nil {
+				return 0, err
+			}
+			numBytes, hasNumBytes = v, true
+		case id >= 2 && id <= 4 && typ == thriftStruct:
+			// The algorithm, hash and compression each only have one
+			// member, field 1, for the block algorithm, xxHash and no
+			// compression respectively.
+			var member int16
+			memberTyp, err := readThriftField(r, &member)
+			if err != nil {
+				return 0, err
+			}
+			if member != 1 || memberTyp != thriftStruct {
+				return 0, fmt.Errorf("%w: unsupported field %d of field %d",
+					ErrInvalidSplitBlockHeader, member, id)
+			}
+			if err := skipThrift(r, thriftStruct, 0); err != nil {
+				return 0, err
+			}
+			if err := skipThrift(r, thriftStruct, 0); err != nil {
+				return 0, err
+			}
+			unions++
+		default:
+			if err := skipThrift(r, typ, 0); err != nil {
+				return 0, err
+			}
+		}
+	}
+	if !hasNumBytes || unions != 3 {
+		return 0, fmt.Errorf("%w: missing required fields", ErrInvalidSplitBlockHeader)
+	}
+	if numBytes <= 0 || numBytes > maxSplitBlockFilterBytes {
+		return 0, fmt.Errorf("%w: %d bytes is outside of [%d, %d]",
+			ErrPayloadLength, numBytes, minSplitBlockFilterBytes, maxSplitBlockFilterBytes)
+	}
+	if numBytes%splitBlockBytes != 0 {
+		return 0, fmt.Errorf("%w: %d bytes is not a whole number of blocks",
+			ErrPayloadLength, numBytes)
+	}
+	return uint64(numBytes), nil
+}
+
+// readThriftField reads a field header, returning its type, or zero at the
+// end of a struct, and updating id from the id of the previous field.
+func readThriftField(r io.ByteReader, id *int16) (byte, error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return 0, readErr(err)
+	}
+	typ := b & 0x0f
+	if typ == 0 {
+		return 0, nil
+	}
+	if delta := b >> 4; delta != 0 {
+		*id += int16(delta)
+		return typ, nil
+	}
+	v, err := readThriftInt(r)
+	if err != nil {
+		return 0, err
+	}
+	*id = int16(v)
+	return typ, nil
+}
+
+func readThriftInt(r io.ByteReader) (int64, error) {
+	v, err := binary.ReadUvarint(r)
+	if err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return 0, ErrTruncated
+		}
+		return 0, fmt.Errorf("%w: %v", ErrInvalidSplitBlockHeader, err)
+	}
+	return int64(v>>1) ^ -int64(v&1), nil
+}
+
+// skipThrift skips a value of a type.
+func skipThrift(r io.ByteReader, typ byte, depth int) error {
+	if depth > maxThriftDepth {
+		return fmt.Errorf("%w: nested too deeply", ErrInvalidSplitBlockHeader)
+	}
+	switch typ {
+	case thriftTrue, thriftFalse:
+		return nil
+	case thriftByte:
+		return skipBytes(r, 1)
+	case thriftI16, thriftI32, thriftI64:
+		_, err := readThriftInt(r)
+		return err
+	case thriftDouble:
+		return skipBytes(r, 8)
+	case thriftBinary:
+		n, err := readThriftLen(r)
+		if err != nil {
+			return err
+		}
+		return skipBytes(r, n)
+	case thriftList, thriftSet:
+		b, err := r.ReadByte()
+		if err != nil {
+			return readErr(err)
+		}
+		n, elem := uint64(b>>4), thriftElem(b&0x0f)
+		if n == 15 {
+			if n, err = readThriftLen(r); err != nil {
+				return err
+			}
+		}
+		for i := uint64(0); i < n; i++ {
+			if err := skipThrift(r, elem, depth+1); err != nil {
+				return err
+			}
+		}
+		return nil
+	case thriftMap:
+		n, err := readThriftLen(r)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return nil
+		}
+		b, err := r.ReadByte()
+		if err != nil {
+			return readErr(err)
+		}
+		key, value := thriftElem(b>>4), thriftElem(b&0x0f)
+		for i := uint64(0); i < n; i++ {
+			if err := skipThrift(r, key, depth+1); err != nil {
+				return err
+			}
+			if err := skipThrift(r, value, depth+1); err != nil {
+				return err
+			}
+		}
+		return nil
+	case thriftStruct:
+		var id int16
+		for {
+			typ, err := readThriftField(r, &id)
+			if err != nil {
+				return err
+			}
+			if typ == 0 {
+				return nil
+			}
+			if err := skipThrift(r, typ, depth+1); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("%w: unknown field type %d", ErrInvalidSplitBlockHeader, typ)
+	}
+}
+
+// thriftElem returns the type an element of a container is encoded as,
+// booleans in lists, sets and maps are encoded as a byte each rather than
+// in their type. Every element is then at least one byte.
+func thriftElem(typ byte) byte {
+	if typ == thriftTrue || typ == thriftFalse {
+		return thriftByte
+	}
+	return typ
+}
+
+// readThriftLen reads the length of a binary or the number of elements of
+// a container, each of which is at least one byte, rejecting lengths
+// longer than the remaining input when it is known so that a corrupted
+// length fails before skipping anything.
+func readThriftLen(r io.ByteReader) (uint64, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return 0, readErr(err)
+	}
+	if l, ok := r.(interface{ Len() int }); ok {
+		if remaining := l.Len(); remaining >= 0 && n > uint64(remaining) {
+			return 0, ErrTruncated
+		}
+	}
+	return n, nil
+}
+
+func skipBytes(r io.ByteReader, n uint64) error {
+	for i := uint64(0); i < n; i++ {
+		if _, err := r.ReadByte(); err != nil {
+			return readErr(err)
+		}
+	}
+	return nil
+}
+
+func zigzag32(v int32) uint64 {
+	return uint64(uint32((v << 1) ^ (v >> 31)))
+}
+
+// byteReader reads single bytes from a reader without reading ahead, so
+// the reader can continue to be read from after a header.
+type byteReader struct {
+	r   io.Reader
+	buf [1]byte
+}
+
+func (r *byteReader) ReadByte() (byte, error) {
+	_, err := io.ReadFull(r.r, r.buf[:])
+	return r.buf[0], err
+}
+
+type countingByteReader struct {
+	r io.ByteReader
+	n int64
+}
+
+// Len returns the number of unread bytes if the underlying reader knows
+// it, or -1 otherwise.
+func (r *countingByteReader) Len() int {
+	if l, ok := r.r.(interface{ Len() int }); ok {
+		return l.Len()
+	}
+	return -1
+}
+
+func (r *countingByteReader) ReadByte() (byte, error) {
+	b, err := r.r.ReadByte()
+	if err == nil {
+		r.n++
+	}
+	return b, err
+}
@@ -0,0 +1,192 @@
+package bloom
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitBlockBloomFilter(t *testing.T) {
+	f := NewSplitBlockBloomFilter(1000)
+	n1 := []byte("Bess")
+	n2 := []byte("Jane")
+	n3 := []byte("Emma")
+	f.Add(n1)
+	f.Add(n3)
+
+	require.Equal(t, uint(1024*8), f.M())
+	require.Equal(t, uint(8), f.K())
+	require.True(t, f.Test(n1))
+	require.False(t, f.Test(n2))
+	require.True(t, f.Test(n3))
+	require.True(t, f.TestHash(xxhash64(n1, 0)))
+}
+
+func TestSplitBlockBloomFilterSize(t *testing.T) {
+	require.Len(t, NewSplitBlockBloomFilter(0).Bytes(), 32)
+	require.Len(t, NewSplitBlockBloomFilter(33).Bytes(), 64)
+	require.Len(t, NewSplitBlockBloomFilter(1<<30).Bytes(), 128*1024*1024)
+
+	size, err := SplitBlockBytesForFalsePositiveRate(1000000, 0.01)
+	require.NoError(t, err)
+	// About 10 bits per value are required at a false positive rate of 1%.
+	require.InDelta(t, 10*1000000/8, float64(size), 0.1*10*1000000/8)
+
+	_, err = SplitBlockBytesForFalsePositiveRate(0, 0.01)
+	require.Equal(t, ErrZeroN, err)
+	_, err = SplitBlockBytesForFalsePositiveRate(1000, 1)
+	require.True(t, errors.Is(err, ErrInvalidFalsePositiveRate))
+}
+
+func TestSplitBlockBloomFilterLayout(t *testing.T) {
+	f := NewSplitBlockBloomFilter(64)
+
+	// A hash of 1 selects block 0 and sets bit salt>>27 of each word.
+	f.AddHash(1)
+	expected := make([]byte, 64)
+	for i, salt := range []uint32{
+		0x47b6137b, 0x44974d91, 0x8824ad5b, 0xa2b7289d,
+		0x705495c7, 0x2df1424b, 0x9efc4947, 0x5c6bfb31,
+	} {
+		endianness.PutUint32(expected[4*i:], 1<<(salt>>27))
+	}
+	require.Equal(t, expected, f.Bytes())
+
+	// The upper 32 bits select the block by multiplying by the number of
+	// blocks, a key of 0 sets bit 0 of each word.
+	f.AddHash(1 << 63)
+	for i := 0; i < 8; i++ {
+		expected[32+4*i] = 1
+	}
+	require.Equal(t, expected, f.Bytes())
+}
+
+func TestSplitBlockBloomFilterWriteToReadFrom(t *testing.T) {
+	f := NewSplitBlockBloomFilter(1024)
+	n1 := []byte("Bess")
+	n2 := []byte("Jane")
+	f.Add(n1)
+
+	buf := bytes.NewBuffer(nil)
+	written, err := f.WriteTo(buf)
+	require.NoError(t, err)
+	require.Equal(t, int64(buf.Len()), written)
+
+	// The header is a Thrift compact protocol encoded BloomFilterHeader.
+	header := []byte{
+		0x15, 0x80, 0x10, // numBytes: 1024
+		0x1c, 0x1c, 0x00, 0x00, // algorithm: BLOCK
+		0x1c, 0x1c, 0x00, 0x00, // hash: XXHASH
+		0x1c, 0x1c, 0x00, 0x00, // compression: UNCOMPRESSED
+		0x00,
+	}
+	require.Equal(t, header, buf.Bytes()[:len(header)])
+	require.Equal(t, f.Bytes(), buf.Bytes()[len(header):])
+
+	var r SplitBlockBloomFilter
+	read, err := r.ReadFrom(iotest.OneByteReader(bytes.NewReader(buf.Bytes())))
+	require.NoError(t, err)
+	require.Equal(t, written, read)
+	require.Equal(t, f.Bytes(), r.Bytes())
+
+	// Trailing data after the bitset is ignored.
+	data := append(buf.Bytes(), 0xff, 0xff)
+	ro, err := NewReadOnlySplitBlockBloomFilterFromBytes(data)
+	require.NoError(t, err)
+	require.Equal(t, f.M(), ro.M())
+	require.True(t, ro.Test(n1))
+	require.False(t, ro.Test(n2))
+
+	ro, err = NewReadOnlySplitBlockBloomFilter(f.Bytes())
+	require.NoError(t, err)
+	require.True(t, ro.Test(n1))
+	require.False(t, ro.Test(n2))
+}
+
+func TestSplitBlockBloomFilterHeaderUnknownFields(t *testing.T) {
+	header := []byte{
+		0x15, 0x40, // numBytes: 32
+		0x1c, 0x1c, 0x00, 0x00,
+		0x1c, 0x1c, 0x00, 0x00,
+		0x1c, 0x1c, 0x00, 0x00,
+		0x18, 0x02, 'h', 'i', // field 5: binary
+		0x19, 0x25, 0x02, 0x04, // field 6: list<i32>
+		0x00,
+	}
+	data := append(header, make([]byte, 32)...)
+	ro, err := NewReadOnlySplitBlockBloomFilterFromBytes(data)
+	require.NoError(t, err)
+	require.Equal(t, uint(256), ro.M())
+}
+
+func TestSplitBlockBloomFilterHeaderErrors(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	_, err := NewSplitBlockBloomFilter(32).WriteTo(buf)
+	require.NoError(t, err)
+	data := buf.Bytes()
+
+	corrupt := func(offset int, value byte) []byte {
+		c := append([]byte(nil), data...)
+		c[offset] = value
+		return c
+	}
+
+	unions := []byte{
+		0x1c, 0x1c, 0x00, 0x00,
+		0x1c, 0x1c, 0x00, 0x00,
+		0x1c, 0x1c, 0x00, 0x00,
+		0x00,
+	}
+	header := func(fields ...byte) []byte {
+		return append(fields, unions...)
+	}
+
+	tests := []struct {
+		name     string
+		data     []byte
+		expected error
+	}{
+		{name: "short header", data: data[:5], expected: ErrTruncated},
+		{
+			name:     "oversized num bytes",
+			data:     header(0x15, 0x80, 0x80, 0x80, 0x80, 0x80, 0x40), // 1 << 40
+			expected: ErrPayloadLength,
+		},
+		{name: "negative num bytes", data: header(0x15, 0x3f), expected: ErrPayloadLength},
+		{
+			// A map<bool, bool> of 2^64-1 entries, each encoded as two bytes.
+			name: "map count",
+			data: header(0x15, 0x40, 0x1b,
+				0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01, 0x11),
+			expected: ErrTruncated,
+		},
+		{
+			name:     "list count",
+			data:     header(0x15, 0x40, 0x19, 0xf1, 0xff, 0xff, 0xff, 0xff, 0x0f),
+			expected: ErrTruncated,
+		},
+		{name: "short bitset", data: data[:len(data)-1], expected: ErrTruncated},
+		{name: "num bytes", data: corrupt(1, 0x42), expected: ErrPayloadLength},
+		{name: "algorithm", data: corrupt(3, 0x15), expected: ErrInvalidSplitBlockHeader},
+		{name: "hash", data: corrupt(7, 0x2c), expected: ErrInvalidSplitBlockHeader},
+		{name: "field type", data: corrupt(0, 0x1f), expected: ErrInvalidSplitBlockHeader},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewReadOnlySplitBlockBloomFilterFromBytes(tt.data)
+			require.True(t, errors.Is(err, tt.expected), "unexpected error: %v", err)
+
+			var r SplitBlockBloomFilter
+			_, err = r.ReadFrom(bytes.NewReader(tt.data))
+			require.True(t, errors.Is(err, tt.expected), "unexpected error: %v", err)
+
+			// A reader that does not know its remaining length.
+			_, err = r.ReadFrom(struct{ io.Reader }{bytes.NewReader(tt.data)})
+			require.True(t, errors.Is(err, tt.expected), "unexpected error: %v", err)
+		})
+	}
+}
@@ -0,0 +1,255 @@
+package bloom
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+const (
+	// defaultCellWidth is the width in bits of the cells of a stable bloom
+	// filter when none is specified.
+	defaultCellWidth = 3
+	// defaultStableFalsePositiveRate is the steady state false positive
+	// rate a stable bloom filter is bounded by when neither its decrements
+	// nor its false positive rate are specified.
+	defaultStableFalsePositiveRate = 0.01
+)
+
+var (
+	// ErrInvalidCellWidth is returned when creating a stable bloom filter
+	// with a cell width outside of [1, 32].
+	ErrInvalidCellWidth = errors.New("bloom: cell width must be in [1, 32]")
+	// ErrInvalidDecrements is returned when creating a stable bloom filter
+	// that decrements more cells than it has per value added.
+	ErrInvalidDecrements = errors.New("bloom: decrements must be in [1, m]")
+)
+
+// StableOptions are options for creating a stable bloom filter.
+type StableOptions struct {
+	// CellWidth is the width in bits of each cell, from 1 to 32, or zero
+	// for 3 bit cells.
+	CellWidth uint
+	// Decrements is the number of cells decremented each time a value is
+	// added, from 1 to m, or zero for the fewest decrements that bound the
+	// steady state false positive rate by FalsePositiveRate.
+	Decrements uint
+	// FalsePositiveRate is the steady state false positive rate used to
+	// choose the decrements when Decrements is zero, or zero for 0.01.
+	FalsePositiveRate float64
+	// Hasher is the hasher used, or nil for Murmur3Hasher.
+	Hasher Hasher
+}
+
+// StableBloomFilter is a stable bloom filter set membership for detecting
+// duplicates in an unbounded stream of values, as described by Deng and
+// Rafiei in "Approximately Detecting Duplicates for Streaming Data using
+// Stable Bloom Filters". Each of the m elements is a cell rather than a
+// bit, adding a value decrements a run of cells starting at a random cell
+// before setting the k cells of the value to their maximum, so old values
+// are evicted to make room for new ones. Once enough values are added the
+// fraction of cells that are zero reaches a stable point and the false
+// positive rate is then bounded by FalsePositiveRate, the cost is false
+// negatives for values that were not added recently.
+// It cannot be concurrently read or written to, a sync.Mutex must be used
+// to guard read/write access if desired.
+type StableBloomFilter struct {
+	m          uint64
+	k          uint64
+	max        uint32
+	decrements uint64
+	cells      packedArray
+	seed       uint64
+	hasher     Hasher
+}
+
+// NewStableBloomFilter creates a new stable bloom filter that can represent
+// m elements with k hashes using 3 bit cells, with a steady state false
+// positive rate of at most 0.01. It is not concurrent read or write safe.
+func NewStableBloomFilter(m uint, k uint) *StableBloomFilter {
+	b, _ := NewStableBloomFilterWithOptions(m, k, StableOptions{})
+	return b
+}
+
+// NewStableBloomFilterWithOptions creates a new stable bloom filter that
+// can represent m elements with k hashes. It returns ErrTooSmall if the
+// false positive rate cannot be reached with m elements.
+// It is not concurrent read or write safe.
+func NewStableBloomFilterWithOptions(
+	m uint,
+	k uint,
+	opts StableOptions,
+) (*StableBloomFilter, error) {
+	width := opts.CellWidth
+	if width == 0 {
+		width = defaultCellWidth
+	}
+	if width > maxPackedWidth {
+		return nil, fmt.Errorf("%w: %d", ErrInvalidCellWidth, width)
+	}
+	hasher := opts.Hasher
+	if hasher == nil {
+		hasher = Murmur3Hasher
+	}
+	if m < 1 {
+		m = 1
+	}
+	if k < 1 {
+		k = 1
+	}
+	cellMax := uint32(1<<width - 1)
+	decrements := uint64(opts.Decrements)
+	if decrements == 0 {
+		p := opts.FalsePositiveRate
+		if p == 0 {
+			p = defaultStableFalsePositiveRate
+		}
+		if err := validateFalsePositiveRate(p); err != nil {
+			return nil, err
+		}
+		decrements = stableDecrements(uint64(m), uint64(k), cellMax, p)
+		if decrements > uint64(m) {
+			return nil, fmt.Errorf("%w: m=%d, p=%v", ErrTooSmall, m, p)
+		}
+	}
+	if decrements > uint64(m) {
+		return nil, fmt.Errorf("%w: %d", ErrInvalidDecrements, decrements)
+	}
+	return &StableBloomFilter{
+		m:          uint64(m),
+		k:          uint64(k),
+		max:        cellMax,
+		decrements: decrements,
+		cells:      newPackedArray(uint64(m), width),
+		hasher:     hasher,
+	}, nil
+}
+
+// Add value to the set.
+func (b *StableBloomFilter) Add(value []byte) {
+	b.AddDigest(Digest(b.hasher.Sum(value)))
+}
+
+// AddDigest adds the value with a digest to the set, the digest must
+// have been computed with the hasher of the filter.
+func (b *StableBloomFilter) AddDigest(h Digest) {
+	b.decrement()
+	for i := uint64(0); i < b.k; i++ {
+		b.cells.set(uint64(bloomFilterLocation(h, i, b.m)), b.max)
+	}
+}
+
+// decrement decrements a run of cells starting at a random cell, Deng and
+// Rafiei decrement cells chosen independently but a run decrements each
+// cell with the same probability for fewer random numbers.
+func (b *StableBloomFilter) decrement() {
+	b.seed += 0x9e3779b97f4a7c15
+	loc := splitmix64(b.seed) % b.m
+	for i := uint64(0); i < b.decrements; i++ {
+		if c := b.cells.get(loc); c > 0 {
+			b.cells.set(loc, c-1)
+		}
+		if loc++; loc == b.m {
+			loc = 0
+		}
+	}
+}
+
+// Test if value is in the set.
+func (b *StableBloomFilter) Test(value []byte) bool {
+	return b.TestDigest(Digest(b.hasher.Sum(value)))
+}
+
+// TestDigest tests if the value with a digest is in the set, the
+// digest must have been computed with the hasher of the filter.
+func (b *StableBloomFilter) TestDigest(h Digest) bool {
+	for i := uint64(0); i < b.k; i++ {
+		if b.cells.get(uint64(bloomFilterLocation(h, i, b.m))) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// TestAndAdd tests if value is in the set then adds it, hashing value
+// once. It returns whether value was in the set before it was added.
+func (b *StableBloomFilter) TestAndAdd(value []byte) bool {
+	return b.TestAndAddDigest(Digest(b.hasher.Sum(value)))
+}
+
+// TestAndAddDigest tests if the value with a digest is in the set then
+// adds it like TestAndAdd, the digest must have been computed with the
+// hasher of the filter.
+func (b *StableBloomFilter) TestAndAddDigest(h Digest) bool {
+	found := b.TestDigest(h)
+	b.AddDigest(h)
+	return found
+}
+
+// M returns the m elements represented.
+func (b *StableBloomFilter) M() uint {
+	return uint(b.m)
+}
+
+// K returns the k hashes used.
+func (b *StableBloomFilter) K() uint {
+	return uint(b.k)
+}
+
+// CellWidth returns the width in bits of each cell.
+func (b *StableBloomFilter) CellWidth() uint {
+	return uint(b.cells.width)
+}
+
+// Decrements returns the number of cells decremented per value added.
+func (b *StableBloomFilter) Decrements() uint {
+	return uint(b.decrements)
+}
+
+// Hasher returns the hasher used.
+func (b *StableBloomFilter) Hasher() Hasher {
+	return b.hasher
+}
+
+// StablePoint returns the expected fraction of cells that are zero once
+// the filter reaches its steady state.
+func (b *StableBloomFilter) StablePoint() float64 {
+	return stablePoint(b.m, b.k, b.max, b.decrements)
+}
+
+// FalsePositiveRate returns the false positive rate of the filter once it
+// reaches its steady state, which bounds its false positive rate.
+func (b *StableBloomFilter) FalsePositiveRate() float64 {
+	return math.Pow(1-b.StablePoint(), float64(b.k))
+}
+
+// stablePoint returns the expected fraction of zero cells of a stable bloom
+// filter at its steady state, (1 / (1 + 1/(P(1/K - 1/m))))^Max.
+func stablePoint(m, k uint64, max uint32, decrements uint64) float64 {
+	c := 1/float64(k) - 1/float64(m)
+	if c <= 0 {
+		// Every cell is set by each value added.
+		return 0
+	}
+	return math.Pow(1/(1+1/(float64(decrements)*c)), float64(max))
+}
+
+// stableDecrements returns the fewest decrements for which the steady state
+// false positive rate of a stable bloom filter is at most p, solving
+// (1 - stablePoint)^K = p for P.
+func stableDecrements(m, k uint64, max uint32, p float64) uint64 {
+	c := 1/float64(k) - 1/float64(m)
+	if c <= 0 {
+		return m + 1
+	}
+	zero := 1 - math.Pow(p, 1/float64(k))
+	ideal := 1 / (c * (math.Pow(zero, -1/float64(max)) - 1))
+	if !(ideal < float64(m)) {
+		return m + 1
+	}
+	decrements := uint64(math.Ceil(ideal))
+	if decrements < 1 {
+		decrements = 1
+	}
+	return decrements
+}
@@ -0,0 +1,98 @@
+package bloom
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStableBloomFilter(t *testing.T) {
+	f := NewStableBloomFilter(1000, 4)
+	require.Equal(t, uint(3), f.CellWidth())
+	require.True(t, f.Decrements() >= 1)
+	require.True(t, f.FalsePositiveRate() <= 0.01)
+	n1 := []byte("Bess")
+	n2 := []byte("Jane")
+	n3 := []byte("Emma")
+
+	f.Add(n1)
+	require.False(t, f.TestAndAdd(n3))
+	require.True(t, f.TestAndAdd(n3))
+	require.True(t, f.Test(n1))
+	require.False(t, f.Test(n2))
+	require.True(t, f.Test(n3))
+}
+
+func TestStableBloomFilterEviction(t *testing.T) {
+	f, err := NewStableBloomFilterWithOptions(1000, 3, StableOptions{
+		CellWidth:  1,
+		Decrements: 10,
+	})
+	require.NoError(t, err)
+	require.Equal(t, uint(10), f.Decrements())
+
+	n1 := []byte("Bess")
+	f.Add(n1)
+	var buff [8]byte
+	for i := 0; i < 10000; i++ {
+		endianness.PutUint64(buff[:], uint64(i))
+		f.Add(buff[:])
+	}
+	require.False(t, f.Test(n1))
+}
+
+func TestStableBloomFilterSteadyState(t *testing.T) {
+	const m = 100000
+	f, err := NewStableBloomFilterWithOptions(m, 3, StableOptions{
+		CellWidth:         2,
+		FalsePositiveRate: 0.02,
+	})
+	require.NoError(t, err)
+	require.True(t, f.FalsePositiveRate() <= 0.02)
+	require.True(t, f.FalsePositiveRate() > 0.015)
+
+	// Reach the steady state with a stream of distinct values, then check
+	// the zero cells and false positive rate against their stable point.
+	var buff [8]byte
+	for i := 0; i < 20*m; i++ {
+		endianness.PutUint64(buff[:], uint64(i))
+		f.Add(buff[:])
+	}
+	var zeros int
+	for i := uint64(0); i < m; i++ {
+		if f.cells.get(i) == 0 {
+			zeros++
+		}
+	}
+	require.InDelta(t, f.StablePoint(), float64(zeros)/m, 0.02)
+
+	var falsePositives int
+	for i := 0; i < m; i++ {
+		endianness.PutUint64(buff[:], uint64(100*m+i))
+		if f.TestAndAdd(buff[:]) {
+			falsePositives++
+		}
+	}
+	require.InDelta(t, f.FalsePositiveRate(), float64(falsePositives)/m, 0.005)
+}
+
+func TestStableBloomFilterOptions(t *testing.T) {
+	f, err := NewStableBloomFilterWithOptions(1000, 4, StableOptions{
+		Hasher: WyhashHasher,
+	})
+	require.NoError(t, err)
+	require.Equal(t, WyhashHasher, f.Hasher())
+
+	_, err = NewStableBloomFilterWithOptions(1000, 4, StableOptions{CellWidth: 33})
+	require.True(t, errors.Is(err, ErrInvalidCellWidth))
+
+	_, err = NewStableBloomFilterWithOptions(1000, 4, StableOptions{Decrements: 1001})
+	require.True(t, errors.Is(err, ErrInvalidDecrements))
+
+	_, err = NewStableBloomFilterWithOptions(1000, 4, StableOptions{FalsePositiveRate: 2})
+	require.True(t, errors.Is(err, ErrInvalidFalsePositiveRate))
+
+	_, err = NewStableBloomFilterWithOptions(4, 4, StableOptions{})
+	require.True(t, errors.Is(err, ErrTooSmall))
+}
@@ -0,0 +1,58 @@
+package bloom
+
+import "math"
+
+// Stats are statistics of a bloom filter computed from its bits, they can
+// be used to detect a filter that has been filled with more values than it
+// was sized for and so has a worse false positive rate than planned.
+type Stats struct {
+	// BitsSet is the number of bits set.
+	BitsSet uint64
+	// FillRatio is the fraction of the m bits that are set.
+	FillRatio float64
+	// EstimatedCount is the estimated number of values added, it is +Inf
+	// when every bit is set.
+	EstimatedCount float64
+	// FalsePositiveRate is the current theoretical false positive rate,
+	// the probability that all k bits of a value not in the set are set.
+	FalsePositiveRate float64
+	// SizeBytes is the size of the bits of the filter in bytes.
+	SizeBytes uint64
+}
+
+func newStats(m, k uint64, data []byte) Stats {
+	bitsSet := popcount(data)
+	fill := float64(bitsSet) / float64(m)
+	return Stats{
+		BitsSet:           bitsSet,
+		FillRatio:         fill,
+		EstimatedCount:    estimateCount(m, k, bitsSet),
+		FalsePositiveRate: math.Pow(fill, float64(k)),
+		SizeBytes:         uint64(len(data)),
+	}
+}
+
+// Stats returns statistics of the filter computed from its bits.
+func (b *BloomFilter) Stats() Stats {
+	return newStats(b.m, b.k, b.bitsData())
+}
+
+// Stats returns statistics of the filter computed from its bits.
+func (b *ReadOnlyBloomFilter) Stats() Stats {
+	return newStats(b.m, b.k, b.bitsData())
+}
+
+// Stats returns statistics of the filter computed from its bits.
+func (b *ConcurrentReadOnlyBloomFilter) Stats() Stats {
+	return newStats(b.m, b.k, b.bitsData())
+}
+
+// Stats returns statistics of the filter computed from a copy of its bits.
+func (b *ConcurrentBloomFilter) Stats() Stats {
+	return newStats(b.m, b.k, b.bitsData())
+}
+
+// Stats returns statistics of the filter computed from its bits.
+func (b *PersistentBloomFilter) Stats() Stats {
+	return newStats(b.m, b.k, b.bitsData())
+}
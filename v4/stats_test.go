@@ -0,0 +1,46 @@
+package bloom
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStats(t *testing.T) {
+	const n = 10000
+	m, k := EstimateFalsePositiveRate(n, 0.01)
+	f := NewBloomFilter(m, k)
+
+	stats := f.Stats()
+	require.Equal(t, uint64(0), stats.BitsSet)
+	require.Equal(t, float64(0), stats.FillRatio)
+	require.Equal(t, float64(0), stats.FalsePositiveRate)
+	require.Equal(t, bitSetBytesLen(uint64(m)), stats.SizeBytes)
+
+	for i := 0; i < n; i++ {
+		f.Add([]byte(fmt.Sprintf("series-%d", i)))
+	}
+	stats = f.Stats()
+	require.InDelta(t, 0.5, stats.FillRatio, 0.03)
+	require.InEpsilon(t, n, stats.EstimatedCount, 0.03)
+	require.InEpsilon(t, 0.01, stats.FalsePositiveRate, 0.1)
+	require.Equal(t, stats.FillRatio, float64(stats.BitsSet)/float64(m))
+
+	buf := bytes.NewBuffer(nil)
+	_, err := f.WriteTo(buf)
+	require.NoError(t, err)
+	ro, err := NewReadOnlyBloomFilterFromBytes(buf.Bytes(), ParseOptions{})
+	require.NoError(t, err)
+	require.Equal(t, stats, ro.Stats())
+	cro, err := NewConcurrentReadOnlyBloomFilterFromBytes(buf.Bytes(), ParseOptions{})
+	require.NoError(t, err)
+	require.Equal(t, stats, cro.Stats())
+
+	// Overfilling the filter makes its false positive rate much worse.
+	for i := n; i < 2*n; i++ {
+		f.Add([]byte(fmt.Sprintf("series-%d", i)))
+	}
+	require.True(t, f.Stats().FalsePositiveRate > 10*stats.FalsePositiveRate)
+}
@@ -0,0 +1,138 @@
+package bloom
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+// ErrNoFilters is returned when combining an empty list of filters.
+var ErrNoFilters = errors.New("bloom: no filters to combine")
+
+// Mergeable is a bloom filter that can be combined with other bloom
+// filters of the same m, k and hash scheme. It is implemented by
+// BloomFilter, ConcurrentBloomFilter, PersistentBloomFilter,
+// ReadOnlyBloomFilter, ConcurrentReadOnlyBloomFilter and
+// MappedBloomFilter, which share the bit layout of BloomFilter.
+type Mergeable interface {
+	Sized
+
+	// Hasher returns the hasher used.
+	Hasher() Hasher
+
+	// bitsData returns the bits of the filter as little endian 64 bit
+	// words, callers must not modify the result.
+	bitsData() []byte
+}
+
+var (
+	_ Mergeable = (*BloomFilter)(nil)
+	_ Mergeable = (*ConcurrentBloomFilter)(nil)
+	_ Mergeable = (*PersistentBloomFilter)(nil)
+	_ Mergeable = (*ReadOnlyBloomFilter)(nil)
+	_ Mergeable = (*ConcurrentReadOnlyBloomFilter)(nil)
+	_ Mergeable = (*MappedBloomFilter)(nil)
+)
+
+// IncompatibleFiltersError is returned when combining filters that differ
+// in m, k or hash scheme.
+type IncompatibleFiltersError struct {
+	// Param is the parameter that differs, one of "m", "k" or "hash scheme".
+	Param string
+	// Values are the values of the parameter for each filter.
+	Values [2]uint64
+}
+
+func (e *IncompatibleFiltersError) Error() string {
+	return fmt.Sprintf("bloom: incompatible filters: %s %d != %d",
+		e.Param, e.Values[0], e.Values[1])
+}
+
+// Union adds the values of other to the set by combining their bits,
+// other must have the same m, k and hash scheme.
+func (b *BloomFilter) Union(other Mergeable) error {
+	if err := checkCompatible(b, other); err != nil {
+		return err
+	}
+	data := append([]byte(nil), b.bitsData()...)
+	orBytes(data, other.bitsData())
+	b.set = bitSetFromBytes(b.m, data)
+	return nil
+}
+
+// UnionAll returns a new bloom filter representing the union of the sets
+// of filters, which must all have the same m, k and hash scheme.
+func UnionAll(filters ...Mergeable) (*BloomFilter, error) {
+	if len(filters) == 0 {
+		return nil, ErrNoFilters
+	}
+	first := filters[0]
+	data := append([]byte(nil), first.bitsData()...)
+	for _, f := range filters[1:] {
+		if err := checkCompatible(first, f); err != nil {
+			return nil, err
+		}
+		orBytes(data, f.bitsData())
+	}
+	return &BloomFilter{
+		m:      uint64(first.M()),
+		k:      uint64(first.K()),
+		set:    bitSetFromBytes(uint64(first.M()), data),
+		hasher: first.Hasher(),
+	}, nil
+}
+
+func checkCompatible(a, b Mergeable) error {
+	if a.M() != b.M() {
+		return &IncompatibleFiltersError{
+			Param:  "m",
+			Values: [2]uint64{uint64(a.M()), uint64(b.M())},
+		}
+	}
+	if a.K() != b.K() {
+		return &IncompatibleFiltersError{
+			Param:  "k",
+			Values: [2]uint64{uint64(a.K()), uint64(b.K())},
+		}
+	}
+	if as, bs := a.Hasher().Scheme(), b.Hasher().Scheme(); as != bs {
+		return &IncompatibleFiltersError{
+			Param:  "hash scheme",
+			Values: [2]uint64{uint64(as), uint64(bs)},
+		}
+	}
+	return nil
+}
+
+// orBytes sets dst to the bitwise or of dst and src.
+func orBytes(dst, src []byte) {
+	if len(src) > len(dst) {
+		src = src[:len(dst)]
+	}
+	for i, v := range src {
+		dst[i] |= v
+	}
+}
+
+func (b *BloomFilter) bitsData() []byte {
+	buf := bytes.NewBuffer(make([]byte, 0, bitSetBytesLen(b.m)))
+	// Writing to a bytes.Buffer does not fail.
+	_ = b.set.Write(buf)
+	return buf.Bytes()
+}
+
+func (b *ConcurrentBloomFilter) bitsData() []byte {
+	return b.snapshot()
+}
+
+func (b *PersistentBloomFilter) bitsData() []byte {
+	return b.payload
+}
+
+func (b *ReadOnlyBloomFilter) bitsData() []byte {
+	return b.data
+}
+
+func (b *ConcurrentReadOnlyBloomFilter) bitsData() []byte {
+	return b.data
+}
@@ -0,0 +1,104 @@
+package bloom
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnion(t *testing.T) {
+	n1 := []byte("Bess")
+	n2 := []byte("Jane")
+	n3 := []byte("Emma")
+
+	a := NewBloomFilter(1000, 4)
+	a.Add(n1)
+	b := NewBloomFilter(1000, 4)
+	b.Add(n2)
+
+	require.NoError(t, a.Union(b))
+	require.True(t, a.Test(n1))
+	require.True(t, a.Test(n2))
+	require.False(t, a.Test(n3))
+
+	// Read only filters can be merged into a mutable filter.
+	c := NewBloomFilter(1000, 4)
+	c.Add(n3)
+	buf := bytes.NewBuffer(nil)
+	_, err := c.WriteTo(buf)
+	require.NoError(t, err)
+	ro, err := NewReadOnlyBloomFilterFromBytes(buf.Bytes(), ParseOptions{})
+	require.NoError(t, err)
+	require.NoError(t, a.Union(ro))
+	require.True(t, a.Test(n3))
+
+	// The union can be written and read back.
+	buf.Reset()
+	_, err = a.WriteTo(buf)
+	require.NoError(t, err)
+	var r BloomFilter
+	_, err = r.ReadFrom(buf)
+	require.NoError(t, err)
+	for _, n := range [][]byte{n1, n2, n3} {
+		require.True(t, r.Test(n))
+	}
+}
+
+func TestUnionAll(t *testing.T) {
+	values := [][]byte{[]byte("Bess"), []byte("Jane"), []byte("Emma"), []byte("Love")}
+
+	a := NewBloomFilter(1000, 4)
+	a.Add(values[0])
+	b := NewConcurrentBloomFilter(1000, 4)
+	b.Add(values[1])
+	c := NewBloomFilter(1000, 4)
+	c.Add(values[2])
+	buf := bytes.NewBuffer(nil)
+	_, err := c.WriteTo(buf)
+	require.NoError(t, err)
+	cro, err := NewConcurrentReadOnlyBloomFilterFromBytes(buf.Bytes(), ParseOptions{})
+	require.NoError(t, err)
+
+	u, err := UnionAll(a, b, cro)
+	require.NoError(t, err)
+	require.Equal(t, a.M(), u.M())
+	require.Equal(t, a.K(), u.K())
+	require.Equal(t, a.Hasher(), u.Hasher())
+	for _, v := range values[:3] {
+		require.True(t, u.Test(v))
+	}
+	require.False(t, u.Test(values[3]))
+
+	// The inputs are not modified.
+	require.False(t, a.Test(values[1]))
+
+	_, err = UnionAll()
+	require.Equal(t, ErrNoFilters, err)
+}
+
+func TestUnionIncompatible(t *testing.T) {
+	a := NewBloomFilter(1000, 4)
+	tests := []struct {
+		name  string
+		other Mergeable
+		param string
+	}{
+		{name: "m", other: NewBloomFilter(2000, 4), param: "m"},
+		{name: "k", other: NewBloomFilter(1000, 5), param: "k"},
+		{name: "hasher", other: NewBloomFilterWithHasher(1000, 4, WyhashHasher), param: "hash scheme"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var incompatible *IncompatibleFiltersError
+			err := a.Union(tt.other)
+			require.True(t, errors.As(err, &incompatible), "unexpected error: %v", err)
+			require.Equal(t, tt.param, incompatible.Param)
+
+			_, err = UnionAll(a, tt.other)
+			require.True(t, errors.As(err, &incompatible), "unexpected error: %v", err)
+			require.Equal(t, tt.param, incompatible.Param)
+		})
+	}
+}
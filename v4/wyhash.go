@@ -0,0 +1,68 @@
+package bloom
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+// wyhash secret, the default _wyp of the reference implementation.
+var wyp = [4]uint64{
+	0x2d358dccaa6c78a5,
+	0x8bb84b93962eacc9,
+	0x4b33a62ed433d4a3,
+	0x4d5a2da51de1aa47,
+}
+
+// wyhash returns the 64 bit wyhash (final version 4) of data with a seed
+// and the default secret, code equivalent to the reference wyhash.h.
+func wyhash(data []byte, seed uint64) uint64 {
+	var (
+		a, b uint64
+		n    = len(data)
+	)
+	seed ^= wymix(seed^wyp[0], wyp[1])
+	if n <= 16 {
+		if n >= 4 {
+			a = wyr4(data)<<32 | wyr4(data[(n>>3)<<2:])
+			b = wyr4(data[n-4:])<<32 | wyr4(data[n-4-((n>>3)<<2):])
+		} else if n > 0 {
+			a = uint64(data[0])<<16 | uint64(data[n>>1])<<8 | uint64(data[n-1])
+		}
+	} else {
+		p := data
+		if len(p) > 48 {
+			see1, see2 := seed, seed
+			for len(p) > 48 {
+				seed = wymix(wyr8(p)^wyp[1], wyr8(p[8:])^seed)
+				see1 = wymix(wyr8(p[16:])^wyp[2], wyr8(p[24:])^see1)
+				see2 = wymix(wyr8(p[32:])^wyp[3], wyr8(p[40:])^see2)
+				p = p[48:]
+			}
+			seed ^= see1 ^ see2
+		}
+		for len(p) > 16 {
+			seed = wymix(wyr8(p)^wyp[1], wyr8(p[8:])^seed)
+			p = p[16:]
+		}
+		// The final 16 bytes may overlap bytes already mixed.
+		a = wyr8(data[n-16:])
+		b = wyr8(data[n-8:])
+	}
+	a ^= wyp[1]
+	b ^= seed
+	hi, lo := bits.Mul64(a, b)
+	return wymix(lo^wyp[0]^uint64(n), hi^wyp[1])
+}
+
+func wymix(a, b uint64) uint64 {
+	hi, lo := bits.Mul64(a, b)
+	return hi ^ lo
+}
+
+func wyr8(p []byte) uint64 {
+	return binary.LittleEndian.Uint64(p)
+}
+
+func wyr4(p []byte) uint64 {
+	return uint64(binary.LittleEndian.Uint32(p))
+}